@@ -0,0 +1,156 @@
+package vibrant
+
+import (
+	"bufio"
+	"context"
+	"image"
+	"io"
+	"os"
+	"runtime"
+)
+
+// A Source provides an encoded image for NewPaletteBatch.
+//
+// Images are decoded with image.Decode, so the formats have to be
+// registered by the caller, e.g.
+//
+//	import _ "image/jpeg"
+type Source interface {
+	Open() (io.ReadCloser, error)
+}
+
+// A Source which reads the file at this path.
+type FileSource string
+
+func (f FileSource) Open() (io.ReadCloser, error) {
+	return os.Open(string(f))
+}
+
+// Returns a Source which reads r, closing it afterwards if it is an
+// io.ReadCloser.
+func ReaderSource(r io.Reader) Source {
+	return readerSource{r}
+}
+
+type readerSource struct {
+	r io.Reader
+}
+
+func (s readerSource) Open() (io.ReadCloser, error) {
+	if rc, ok := s.r.(io.ReadCloser); ok {
+		return rc, nil
+	}
+	return io.NopCloser(s.r), nil
+}
+
+// The result of processing a single Source with NewPaletteBatch.
+type BatchResult struct {
+	Index   int // of Source in the input, starting at 0
+	Source  Source
+	Palette Palette
+	Err     error // from opening, decoding or creating the Palette
+}
+
+// Creates a Palette for every Source received from sources with opts,
+// using up to workers goroutines (0 means runtime.GOMAXPROCS(0)).
+//
+// Results are sent on the returned channel in the same order as sources,
+// which is closed once sources is closed and every result has been sent,
+// or as soon as ctx is done. An error with one Source does not stop the
+// others, it is reported in its BatchResult.
+//
+// At most workers Sources are in progress or waiting to be received at a
+// time, so the returned channel has to be drained (or ctx cancelled) for
+// the batch to make progress.
+func NewPaletteBatch(ctx context.Context, sources <-chan Source, opts Options, workers int) <-chan BatchResult {
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	type job struct {
+		index  int
+		source Source
+		result chan BatchResult
+	}
+	jobs := make(chan *job)
+	// jobs in input order, which also limits how many are in flight: the
+	// one the goroutine sending results holds plus workers-1 buffered ones
+	pending := make(chan *job, workers-1)
+	results := make(chan BatchResult)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			// reused for every image this worker processes
+			buf := bufio.NewReader(nil)
+			e := NewExtractor(opts)
+			for j := range jobs {
+				p, err := decodePalette(ctx, j.source, buf, e)
+				j.result <- BatchResult{Index: j.index, Source: j.source, Palette: p, Err: err}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(pending)
+		defer close(jobs)
+		for index := 0; ; index++ {
+			var src Source
+			var ok bool
+			select {
+			case src, ok = <-sources:
+				if !ok {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+			j := &job{index, src, make(chan BatchResult, 1)}
+			select {
+			case pending <- j:
+			case <-ctx.Done():
+				return
+			}
+			jobs <- j
+		}
+	}()
+
+	go func() {
+		defer close(results)
+		for j := range pending {
+			var res BatchResult
+			select {
+			case res = <-j.result:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case results <- res:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return results
+}
+
+// Opens and decodes src using buf, then creates its Palette with e.
+func decodePalette(ctx context.Context, src Source, buf *bufio.Reader, e *Extractor) (Palette, error) {
+	if err := ctx.Err(); err != nil {
+		return Palette{}, err
+	}
+	r, err := src.Open()
+	if err != nil {
+		return Palette{}, err
+	}
+	buf.Reset(r)
+	img, _, err := image.Decode(buf)
+	buf.Reset(nil)
+	if closeErr := r.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return Palette{}, err
+	}
+	return e.ExtractContext(ctx, img)
+}
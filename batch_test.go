@@ -0,0 +1,132 @@
+package vibrant
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/png"
+	"io"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func encodeTestPNG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, newTestImage(width, height)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func sendSources(sources ...Source) <-chan Source {
+	ch := make(chan Source, len(sources))
+	for _, src := range sources {
+		ch <- src
+	}
+	close(ch)
+	return ch
+}
+
+// Waits for the number of goroutines to drop to n, see TestNewPaletteBatchCancel.
+func waitForGoroutines(t *testing.T, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines still running, want %d", runtime.NumGoroutine(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNewPaletteBatch(t *testing.T) {
+	valid := encodeTestPNG(t, 40, 30)
+	want, err := NewPaletteWithOptions(newTestImage(40, 30), DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(t.TempDir(), "missing.png")
+	failing := map[int]bool{1: true, 2: true, 4: true}
+
+	for _, workers := range []int{1, 3} {
+		sources := []Source{
+			ReaderSource(bytes.NewReader(valid)),
+			FileSource(missing),
+			ReaderSource(bytes.NewReader([]byte("not an image"))),
+			ReaderSource(bytes.NewReader(valid)),
+			ReaderSource(bytes.NewReader([]byte{})),
+			ReaderSource(bytes.NewReader(valid)),
+		}
+		i := 0
+		for res := range NewPaletteBatch(context.Background(), sendSources(sources...), DefaultOptions(), workers) {
+			if res.Index != i || res.Source != sources[i] {
+				t.Fatalf("workers=%d: got result %d, want %d", workers, res.Index, i)
+			}
+			if failing[i] {
+				if res.Err == nil {
+					t.Errorf("workers=%d: %d: no error", workers, i)
+				}
+			} else if res.Err != nil {
+				t.Errorf("workers=%d: %d: %v", workers, i, res.Err)
+			} else if got := res.Palette.Swatches(); fmt.Sprint(got) != fmt.Sprint(want.Swatches()) {
+				t.Errorf("workers=%d: %d: got %v, want %v", workers, i, got, want.Swatches())
+			}
+			i++
+		}
+		if i != len(sources) {
+			t.Errorf("workers=%d: got %d results, want %d", workers, i, len(sources))
+		}
+	}
+}
+
+// A Source which counts how often it was opened.
+type countingSource struct {
+	data   []byte
+	opened *int32
+}
+
+func (c countingSource) Open() (io.ReadCloser, error) {
+	atomic.AddInt32(c.opened, 1)
+	return io.NopCloser(bytes.NewReader(c.data)), nil
+}
+
+func TestNewPaletteBatchCancel(t *testing.T) {
+	valid := encodeTestPNG(t, 40, 30)
+	before := runtime.NumGoroutine()
+
+	for _, workers := range []int{1, 2, 4} {
+		var opened int32
+		sources := make(chan Source)
+		ctx, cancel := context.WithCancel(context.Background())
+		results := NewPaletteBatch(ctx, sources, DefaultOptions(), workers)
+
+		// nothing is received, so the batch stalls once workers
+		// Sources are in flight
+		go func() {
+			for {
+				select {
+				case sources <- countingSource{valid, &opened}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		time.Sleep(50 * time.Millisecond)
+		if n := atomic.LoadInt32(&opened); n > int32(workers) {
+			t.Errorf("workers=%d: %d Sources opened", workers, n)
+		}
+
+		cancel()
+		// at most the result which was already being sent
+		n := 0
+		for range results {
+			n++
+		}
+		if n > 1 {
+			t.Errorf("workers=%d: %d results after cancel", workers, n)
+		}
+		waitForGoroutines(t, before)
+	}
+}
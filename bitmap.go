@@ -1,11 +1,10 @@
 package vibrant
 
 import (
+	"context"
 	"image"
 	"image/color"
 	"math"
-
-	"github.com/nfnt/resize"
 )
 
 // type bitmap is a simple wrapper for an image.Image
@@ -13,28 +12,241 @@ type bitmap struct {
 	Width  int
 	Height int
 	Source image.Image
+
+	// see Options.AlphaThreshold and Options.Background
+	AlphaThreshold uint8
+	Background     color.Color
+
+	// see Options.Mask and Options.WeightByMask
+	// Mask always has the same size as Source, but not necessarily
+	// the same origin.
+	Mask         image.Image
+	WeightByMask bool
 }
 
 func newBitmap(input image.Image) *bitmap {
 	bounds := input.Bounds()
-	return &bitmap{bounds.Dx(), bounds.Dy(), input}
+	return &bitmap{Width: bounds.Dx(), Height: bounds.Dy(), Source: input}
+}
+
+// Sets the mask for this bitmap, which is in the same coordinate space as
+// bitmap.Source. Pixels outside of mask's bounds are masked out.
+func (b *bitmap) setMask(mask image.Image, weighted bool) {
+	b.Mask = cropImage(mask, b.Source.Bounds())
+	b.WeightByMask = weighted
+}
+
+// Crops this bitmap to region, which is in bitmap.Source's coordinate space.
+//
+// Cropping happens before any scaling, so pixels outside of region are
+// never blended into the result and the resize threshold applies to the
+// size of region rather than the whole image.
+func (b *bitmap) crop(region image.Rectangle) error {
+	region = region.Intersect(b.Source.Bounds())
+	if region.Empty() {
+		return ErrEmptyRegion
+	}
+	b.Source = cropImage(b.Source, region)
+	if b.Mask != nil {
+		b.Mask = cropImage(b.Mask, region)
+	}
+	b.Width, b.Height = region.Dx(), region.Dy()
+	return nil
 }
 
-// Scales input image.Image by aspect ratio using https://github.com/nfnt/resize
-func newScaledBitmap(input image.Image, ratio float64) *bitmap {
+// Returns img cropped to r, or img itself if r is already its bounds.
+func cropImage(img image.Image, r image.Rectangle) image.Image {
+	if r == img.Bounds() {
+		return img
+	}
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok && r.In(img.Bounds()) {
+		return sub.SubImage(r)
+	}
+	return &croppedImage{img, r}
+}
+
+// Fallback for image.Image implementations without a SubImage method,
+// also used to extend a mask beyond its own bounds.
+type croppedImage struct {
+	image.Image
+	bounds image.Rectangle
+}
+
+func (c *croppedImage) Bounds() image.Rectangle {
+	return c.bounds
+}
+
+// Scales input image.Image by aspect ratio with resampler, which mostly
+// uses https://github.com/nfnt/resize
+func newScaledBitmap(input image.Image, ratio float64, resampler Resampler) *bitmap {
 	bounds := input.Bounds()
 	w := math.Ceil(float64(bounds.Dx()) * ratio)
 	h := math.Ceil(float64(bounds.Dy()) * ratio)
-	return &bitmap{int(w), int(h), resize.Resize(uint(w), uint(h), input, resize.Bilinear)}
+	return &bitmap{Width: int(w), Height: int(h), Source: resampler.resize(int(w), int(h), input)}
 }
 
-// Returns all of the pixels of this bitmap.Source as a 1D array of image/color.Color
-func (b *bitmap) Pixels() []color.Color {
-	c := make([]color.Color, 0)
-	for y := 0; y < b.Height; y++ {
-		for x := 0; x < b.Width; x++ {
-			c = append(c, b.Source.At(x, y))
+// Scales this bitmap down according to resizeArea with resampler, see
+// Options.ResizeBitmapArea and Options.Resampler. If linear is true, the
+// image is scaled in linear light, see Options.LinearLight.
+func (b *bitmap) scaleDown(resizeArea int, resampler Resampler, linear bool) *bitmap {
+	scaleRatio := -1.0
+	if resizeArea > 0 {
+		area := b.Width * b.Height
+		if area > resizeArea {
+			scaleRatio = math.Sqrt(float64(resizeArea) / float64(area))
+		}
+	} else if resizeArea == 0 {
+		minDim := math.Min(float64(b.Width), float64(b.Height))
+		if minDim > calculateBitmapMinDimension {
+			scaleRatio = calculateBitmapMinDimension / minDim
+		}
+	}
+	if scaleRatio <= 0 {
+		return b
+	}
+	var scaled *bitmap
+	// nearest neighbor sampling doesn't blend colors, so there is no
+	// point in doing it in linear light
+	if linear && resampler != ResampleNearest {
+		scaled = newScaledBitmap(linearImage{b.Source}, scaleRatio, resampler)
+		scaled.Source = srgbImage{scaled.Source}
+	} else {
+		scaled = newScaledBitmap(b.Source, scaleRatio, resampler)
+	}
+	scaled.AlphaThreshold = b.AlphaThreshold
+	scaled.Background = b.Background
+	if b.Mask != nil {
+		scaled.Mask = resampler.resize(scaled.Width, scaled.Height, b.Mask)
+		scaled.WeightByMask = b.WeightByMask
+	}
+	return scaled
+}
+
+// Same as scaleDown, but returns ctx.Err() as soon as ctx is done. The
+// resize itself can't be interrupted, so it is left to finish on its own
+// goroutine.
+func (b *bitmap) scaleDownContext(ctx context.Context, resizeArea int, resampler Resampler, linear bool) (*bitmap, error) {
+	if ctx.Done() == nil {
+		return b.scaleDown(resizeArea, resampler, linear), nil
+	}
+	done := make(chan *bitmap, 1)
+	go func() {
+		done <- b.scaleDown(resizeArea, resampler, linear)
+	}()
+	select {
+	case scaled := <-done:
+		return scaled, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Lookup table for srgbToLinear, scaled to 0-0xffff
+var srgbToLinear16 = func() (lut [256]uint32) {
+	for i := range lut {
+		lut[i] = uint32(math.Floor(srgbToLinear(float64(i))*0xffff + 0.5))
+	}
+	return lut
+}()
+
+// Decodes the colors of an image to linear light, still premultiplied by
+// alpha, so that it can be resized without darkening mixtures of bright
+// colors. Pixels are converted as they are read, without copying the
+// image. See srgbImage.
+type linearImage struct {
+	image.Image
+}
+
+func (l linearImage) ColorModel() color.Model {
+	return color.RGBA64Model
+}
+
+func (l linearImage) At(x, y int) color.Color {
+	r, g, b, a := l.Image.At(x, y).RGBA()
+	if a == 0 {
+		return color.RGBA64{}
+	}
+	// un-premultiply, decode, premultiply again
+	r = srgbToLinear16[r*0xffff/a>>8] * a / 0xffff
+	g = srgbToLinear16[g*0xffff/a>>8] * a / 0xffff
+	b = srgbToLinear16[b*0xffff/a>>8] * a / 0xffff
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+}
+
+// Re-encodes the colors of an image created from a linearImage to sRGB.
+type srgbImage struct {
+	image.Image
+}
+
+func (s srgbImage) ColorModel() color.Model {
+	return color.RGBA64Model
+}
+
+func (s srgbImage) At(x, y int) color.Color {
+	r, g, b, a := s.Image.At(x, y).RGBA()
+	if a == 0 {
+		return color.RGBA64{}
+	}
+	encode := func(c uint32) uint16 {
+		c = uint32(clampComponent(linearToSrgb(float64(c)/float64(a)))) * 0x101
+		return uint16(c * a / 0xffff)
+	}
+	return color.RGBA64{encode(r), encode(g), encode(b), uint16(a)}
+}
+
+// Returns the weight of the pixel at x, y in bitmap.Source's coordinate
+// space in the range 0-pixelWeight, see Options.Mask.
+// mask reads bitmap.Mask and is nil if there is no Mask.
+func (b *bitmap) maskWeight(mask pixelReader, x, y int) int64 {
+	if mask == nil {
+		return pixelWeight
+	}
+	src, bounds := b.Source.Bounds(), b.Mask.Bounds()
+	// grayscale intensity of the premultiplied color, which is the alpha
+	// value for an *image.Alpha and the luminance for an *image.Gray,
+	// same as color.Gray16Model
+	r, g, bl, _ := mask(x-src.Min.X+bounds.Min.X, y-src.Min.Y+bounds.Min.Y)
+	v := int64((19595*r + 38470*g + 7471*bl + 1<<15) >> 16)
+	if b.WeightByMask {
+		return v * pixelWeight / 0xffff
+	}
+	if v >= 0x8000 {
+		return pixelWeight
+	}
+	return 0
+}
+
+// Calls fn for every pixel of this bitmap.Source in the rows minY to maxY
+// (exclusive) with its 24-bit packed int color and weight (see pixelWeight),
+// skipping pixels which are too transparent (see alphaToRgb) or masked out.
+//
+// Unless WeightByMask is set, every pixel has a weight of pixelWeight.
+// Stops early with ctx.Err() if ctx is done, which is checked every row.
+func (b *bitmap) eachPixel(ctx context.Context, minY, maxY int, fn func(color int, weight int64)) error {
+	bounds := b.Source.Bounds()
+	read := newPixelReader(b.Source)
+	var mask pixelReader
+	if b.Mask != nil {
+		mask = newPixelReader(b.Mask)
+	}
+	for y := minY; y < maxY; y++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			w := b.maskWeight(mask, x, y)
+			if w == 0 {
+				continue
+			}
+			cr, cg, cb, ca := read(x, y)
+			red, green, blue, ok := alphaToRgb(cr, cg, cb, ca, b.AlphaThreshold, b.Background)
+			if !ok {
+				continue
+			}
+			fn(packColor(red, green, blue), w)
 		}
 	}
-	return c
+	return nil
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -124,25 +125,42 @@ type swatch struct {
 }
 
 func print_json(palette vibrant.Palette) {
-	out := map[string]interface{}{}
-	for name, sw := range palette.ExtractAwesome() {
-		if output_rgb {
-			r, g, b := sw.Color.RGB()
-			out[name] = map[string]int{"r": r, "g": g, "b": b}
-		} else {
-			out[name] = swatch{sw.Color.RGBHex(), sw.Color.TitleTextColor().RGBHex()}
+	// encoding/json sorts map keys, so the object is written by hand
+	// to keep the order of palette.ExtractAwesomeOrdered()
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, res := range palette.ExtractAwesomeOrdered() {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(res.Target.Name)
+		checkErr(err)
+		buf.Write(key)
+		buf.WriteByte(':')
+
+		var out interface{}
+		switch {
+		case res.Missing():
+			out = nil
+		case output_rgb:
+			r, g, b := res.Swatch.Color.RGB()
+			out = map[string]int{"r": r, "g": g, "b": b}
+		default:
+			sw := res.Swatch
+			out = swatch{sw.Color.RGBHex(), sw.Color.TitleTextColor().RGBHex()}
 		}
+		val, err := json.Marshal(out)
+		checkErr(err)
+		buf.Write(val)
 	}
-	var (
-		b   []byte
-		err error
-	)
-	if output_compress {
-		b, err = json.Marshal(out)
-	} else {
-		b, err = json.MarshalIndent(out, "", "  ")
+	buf.WriteByte('}')
+
+	b := buf.Bytes()
+	if !output_compress {
+		var indented bytes.Buffer
+		checkErr(json.Indent(&indented, b, "", "  "))
+		b = indented.Bytes()
 	}
-	checkErr(err)
 
 	str := string(b)
 	if output_lowercase {
@@ -166,7 +184,11 @@ func print_css(palette vibrant.Palette) {
 		tb = ""
 		sc = ""
 	}
-	for name, sw := range palette.ExtractAwesome() {
+	for _, res := range palette.ExtractAwesomeOrdered() {
+		if res.Missing() {
+			continue
+		}
+		name, sw := res.Target.Name, res.Swatch
 		var bgcolor, fgcolor string
 
 		if output_rgb {
@@ -199,7 +221,16 @@ func shorthex(hex string) string {
 }
 
 func print_plain(palette vibrant.Palette) {
-	for name, sw := range palette.ExtractAwesome() {
+	for _, res := range palette.ExtractAwesomeOrdered() {
+		name, sw := res.Target.Name, res.Swatch
+		if output_lowercase {
+			name = strings.ToLower(name)
+		}
+		if res.Missing() {
+			fmt.Printf("% 12s: (missing)\n", name)
+			continue
+		}
+
 		var fmtstr, color string
 		if output_rgb {
 			fmtstr = "% 12s: %- 16s (population: %d)\n"
@@ -208,13 +239,10 @@ func print_plain(palette vibrant.Palette) {
 			fmtstr = "% 12s: %- 6s (population: %d)\n"
 			color = sw.Color.RGBHex()
 		}
-		if output_lowercase {
-			name = strings.ToLower(name)
-		}
 		if output_compress && !output_rgb {
 			color = shorthex(color)
 		}
 
-		fmt.Printf(fmtstr, name, color, sw.Population)
+		fmt.Printf(fmtstr, name, color, sw.MatchedPopulation)
 	}
 }
@@ -19,6 +19,11 @@ func (c Color) RGB() (r, g, b int) {
 	return unpackColor(int(c))
 }
 
+// Returns Hue, Saturation, and Lightness components in the range 0-1
+func (c Color) HSL() (h, s, l float64) {
+	return rgbToHsl(int(c))
+}
+
 // e.g. "#bada55"
 func (c Color) RGBHex() string {
 	r, g, b := unpackColor(int(c))
@@ -91,6 +96,57 @@ func hslToRgb(h, s, l float64) (rgb int) {
 	return packColor(int(r), int(g), int(b))
 }
 
+// converts an sRGB component in the range 0-255 to linear light in the range 0-1
+func srgbToLinear(c float64) float64 {
+	c /= 255.0
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+// inverse of srgbToLinear, the result is not rounded or clamped
+func linearToSrgb(c float64) float64 {
+	if c <= 0.0031308 {
+		return c * 12.92 * 255.0
+	}
+	return (1.055*math.Pow(c, 1/2.4) - 0.055) * 255.0
+}
+
+// clamps c to the range 0-255 and rounds it
+func clampComponent(c float64) int {
+	return int(math.Floor(math.Max(0, math.Min(255, c)) + 0.5))
+}
+
+// given a 24-bit int color, returns its L, a, b components in the OKLab
+// color space, see https://bottosson.github.io/posts/oklab/
+func rgbToOklab(color int) (L, a, b float64) {
+	ir, ig, ib := unpackColorFloat(color)
+	r, g, bl := srgbToLinear(ir), srgbToLinear(ig), srgbToLinear(ib)
+
+	l := math.Cbrt(0.4122214708*r + 0.5363325363*g + 0.0514459929*bl)
+	m := math.Cbrt(0.2119034982*r + 0.6806995451*g + 0.1073969566*bl)
+	s := math.Cbrt(0.0883024619*r + 0.2817188376*g + 0.6299787005*bl)
+
+	L = 0.2104542553*l + 0.7936177850*m - 0.0040720468*s
+	a = 1.9779984951*l - 2.4285922050*m + 0.4505937099*s
+	b = 0.0259040371*l + 0.7827717662*m - 0.8086757660*s
+	return
+}
+
+// inverse of rgbToOklab, out of gamut colors are clamped
+func oklabToRgb(L, a, b float64) (rgb int) {
+	l := L + 0.3963377774*a + 0.2158037573*b
+	m := L - 0.1055613458*a - 0.0638541728*b
+	s := L - 0.0894841775*a - 1.2914855480*b
+	l, m, s = l*l*l, m*m*m, s*s*s
+
+	r := 4.0767416621*l - 3.3077115913*m + 0.2309699292*s
+	g := -1.2684380046*l + 2.6097574011*m - 0.3413193965*s
+	bl := -0.0041960863*l - 0.7034186147*m + 1.7076147010*s
+	return packColor(clampComponent(linearToSrgb(r)), clampComponent(linearToSrgb(g)), clampComponent(linearToSrgb(bl)))
+}
+
 // returns the contrast ratio of 24-bit int colors fg and bg (foreground and background)
 func contrast(fg, bg int) float64 {
 	lum1 := luminance(unpackColorFloat(fg))
@@ -1,10 +1,9 @@
 package vibrant
 
-import "container/heap"
-
-const (
-	blackMaxLightness float64 = 0.05
-	whiteMinLightness float64 = 0.95
+import (
+	"container/heap"
+	"context"
+	"sync"
 )
 
 // A color quantizer based on the Median-cut algorithm, optimized for
@@ -16,73 +15,221 @@ const (
 // An average color is then generated from each cube.
 //
 // Whereas median-cut divides cubes so they all have roughly the same
-// population, this quantizer divides boxes based on their color volume.
+// population, this quantizer divides boxes based on their color volume
+// by default, see MedianCut.
 type colorCutQuantizer struct {
 	Colors           []int
-	ColorPopulations map[int]int
+	ColorPopulations map[int]int64 // see pixelWeight
 	QuantizedColors  []*Swatch
+	Options          MedianCut
+
+	histo *Histogram // being quantized, see Histogram.newSwatch
+
+	// buffers reused by quantizePixels, see colorCutQuantizerPool
+	boxes []vbox
+	queue priorityQueue
+	done  []*vbox
+}
+
+const DEFAULT_TWO_PHASE_FRACTION = 0.75
+
+// Decides which box colorCutQuantizer splits next.
+type SplitPriority int
+
+const (
+	// The box with the largest color volume, as in Android's Palette.
+	PriorityVolume SplitPriority = iota
+	// The box with the largest population.
+	PriorityPopulation
+	// The box with the largest population multiplied by color volume.
+	PriorityPopulationVolume
+	// PriorityPopulation until MedianCut.TwoPhaseFraction of the boxes
+	// exist, then PriorityPopulationVolume, as in Leptonica's modified
+	// median cut quantization (MMCQ).
+	PriorityTwoPhase
+)
+
+func (p SplitPriority) of(v *vbox) float64 {
+	switch p {
+	case PriorityPopulation:
+		return float64(v.Weight())
+	case PriorityPopulationVolume:
+		return float64(v.Weight()) * float64(v.Volume())
+	}
+	return float64(v.Volume())
 }
 
-// true if the color is close to pure black, pure white, or
-// "the red side of the I line" which I believe is Google-speak for
-// "that particular shade of red which occurs in the red-eye effect"
-// see enwp.org/Red-eye_effect
-func shouldIgnoreColor(color int) bool {
+// Decides where colorCutQuantizer splits a box along its longest dimension.
+type SplitPoint int
+
+const (
+	// Half way between the smallest and largest value.
+	SplitMidpoint SplitPoint = iota
+	// So that both halves have about the same population.
+	SplitMedian
+)
+
+// MedianCut configures colorCutQuantizer, the zero value is the same as
+// MedianCutQuantizer.
+//
+//	opts.Quantizer = vibrant.MedianCut{Priority: vibrant.PriorityTwoPhase, SplitAt: vibrant.SplitMedian}
+type MedianCut struct {
+	Priority SplitPriority
+	SplitAt  SplitPoint
+
+	// Fraction of the boxes to create by population when Priority is
+	// PriorityTwoPhase, 0 is the same as DEFAULT_TWO_PHASE_FRACTION.
+	TwoPhaseFraction float64
+
+	// If true, the colors in each box are averaged in linear light rather
+	// than sRGB, which otherwise darkens mixtures of bright colors.
+	// See also Options.LinearLight.
+	LinearLight bool
+}
+
+func (m MedianCut) Quantize(histo *Histogram, maxColors int) []*Swatch {
+	swatches, _ := m.QuantizeContext(context.Background(), histo, maxColors)
+	return swatches
+}
+
+func (m MedianCut) QuantizeContext(ctx context.Context, histo *Histogram, maxColors int) ([]*Swatch, error) {
+	ccq, err := newColorCutQuantizer(ctx, histo, maxColors, m)
+	if err != nil {
+		return nil, err
+	}
+	swatches := ccq.QuantizedColors
+	ccq.release()
+	return swatches, nil
+}
+
+// true if any of filters does not allow the color, see also Filter
+func shouldIgnoreColor(color int, filters []Filter) bool {
+	if len(filters) == 0 {
+		return false
+	}
 	h, s, l := rgbToHsl(color)
-	return l <= blackMaxLightness || l >= whiteMinLightness || (h >= 0.0278 && h <= 0.1028 && s <= 0.82)
+	return !chainFilter(filters).IsAllowed(Color(color), h, s, l)
 }
 
-func shouldIgnoreColorSwatch(sw *Swatch) bool {
-	return shouldIgnoreColor(int(sw.Color))
+func shouldIgnoreColorSwatch(sw *Swatch, filters []Filter) bool {
+	return shouldIgnoreColor(int(sw.Color), filters)
 }
 
-func newColorCutQuantizer(bitmap bitmap, maxColors int) *colorCutQuantizer {
-	pixels := bitmap.Pixels()
-	histo := newColorHistogram(pixels)
-	colorPopulations := make(map[int]int, histo.NumberColors)
-	for i, c := range histo.Colors {
-		colorPopulations[c] = histo.ColorCounts[i]
+// colorCutQuantizers are reused, so that their buffers don't have to be
+// allocated again for every image, see release.
+var colorCutQuantizerPool = sync.Pool{
+	New: func() interface{} {
+		return &colorCutQuantizer{ColorPopulations: make(map[int]int64)}
+	},
+}
+
+// Call release once QuantizedColors are no longer needed.
+func newColorCutQuantizer(ctx context.Context, histo *Histogram, maxColors int, opts MedianCut) (*colorCutQuantizer, error) {
+	ccq := colorCutQuantizerPool.Get().(*colorCutQuantizer)
+	ccq.Options = opts
+	ccq.histo = histo
+	for i, c := range histo.colors {
+		ccq.ColorPopulations[c] = histo.counts[i]
 	}
-	validColors := make([]int, 0)
-	i := 0
-	for _, c := range histo.Colors {
-		if !shouldIgnoreColor(c) {
-			validColors = append(validColors, c)
-			i++
+	// vbox sorts Colors in place
+	ccq.Colors = append(ccq.Colors[:0], histo.colors...)
+	if len(ccq.Colors) > 0 {
+		if err := ccq.quantizePixels(ctx, len(ccq.Colors)-1, maxColors); err != nil {
+			ccq.release()
+			return nil, err
 		}
 	}
-	validCount := len(validColors)
-	ccq := &colorCutQuantizer{Colors: validColors, ColorPopulations: colorPopulations}
-	if validCount <= maxColors {
-		// note: no quantization actually occurs
-		for _, c := range validColors {
-			ccq.QuantizedColors = append(ccq.QuantizedColors, &Swatch{Color: Color(c), Population: colorPopulations[c]})
-		}
-	} else {
-		ccq.quantizePixels(validCount-1, maxColors)
+	return ccq, nil
+}
+
+// Returns ccq to colorCutQuantizerPool, except for QuantizedColors.
+func (ccq *colorCutQuantizer) release() {
+	for c := range ccq.ColorPopulations {
+		delete(ccq.ColorPopulations, c)
 	}
-	return ccq
+	ccq.QuantizedColors = nil
+	ccq.histo = nil
+	colorCutQuantizerPool.Put(ccq)
+}
+
+// Returns the next of the vboxes, which is enough for maxColors boxes.
+func (ccq *colorCutQuantizer) newVbox() *vbox {
+	ccq.boxes = ccq.boxes[:len(ccq.boxes)+1]
+	return &ccq.boxes[len(ccq.boxes)-1]
 }
 
 // see also vbox.go
-func (ccq *colorCutQuantizer) quantizePixels(maxColorIndex, maxColors int) {
-	pq := make(priorityQueue, 0)
-	heap.Init(&pq)
-	heap.Push(&pq, newVbox(0, maxColorIndex, ccq.Colors, ccq.ColorPopulations))
-	for pq.Len() < maxColors {
-		v := heap.Pop(&pq).(*vbox)
-		if v.CanSplit() {
-			heap.Push(&pq, v.Split())
-			heap.Push(&pq, v)
-		} else {
-			break
+func (ccq *colorCutQuantizer) quantizePixels(ctx context.Context, maxColorIndex, maxColors int) error {
+	// there is at most one box per color, or per split plus the first one
+	n := maxColors + 1
+	if maxColorIndex+1 < n {
+		n = maxColorIndex + 1
+	}
+	if cap(ccq.boxes) < n {
+		ccq.boxes = make([]vbox, 0, n)
+	}
+	ccq.boxes = ccq.boxes[:0]
+
+	pq := &ccq.queue
+	pq.items = pq.items[:0]
+	pq.priority = ccq.Options.Priority
+	if pq.priority == PriorityTwoPhase {
+		pq.priority = PriorityPopulation
+	}
+	heap.Init(pq)
+	heap.Push(pq, ccq.newVbox().init(0, maxColorIndex, ccq.Colors, ccq.ColorPopulations))
+
+	// boxes containing a single color, which can't be split any further
+	done := ccq.done[:0]
+	var err error
+
+	if ccq.Options.Priority == PriorityTwoPhase {
+		fraction := ccq.Options.TwoPhaseFraction
+		if fraction <= 0 {
+			fraction = DEFAULT_TWO_PHASE_FRACTION
+		}
+		done, err = ccq.splitBoxes(ctx, pq, done, int(fraction*float64(maxColors)))
+		if err != nil {
+			return err
 		}
+
+		// re-sort the remaining boxes for the second phase
+		pq.priority = PriorityPopulationVolume
+		heap.Init(pq)
+	}
+	done, err = ccq.splitBoxes(ctx, pq, done, maxColors)
+	if err != nil {
+		return err
 	}
+
 	for pq.Len() > 0 {
-		v := heap.Pop(&pq).(*vbox)
-		swatch := v.AverageColor()
-		if !shouldIgnoreColorSwatch(swatch) {
-			ccq.QuantizedColors = append(ccq.QuantizedColors, swatch)
+		done = append(done, heap.Pop(pq).(*vbox))
+	}
+	ccq.done = done
+
+	swatches := make([]Swatch, len(done))
+	ccq.QuantizedColors = make([]*Swatch, len(done))
+	for i, v := range done {
+		swatches[i] = ccq.histo.newSwatch(v.AverageColor(ccq.Options.LinearLight), v.Weight())
+		ccq.QuantizedColors[i] = &swatches[i]
+	}
+	return nil
+}
+
+// Splits the boxes in pq until there are maxColors in total, moving those
+// which can't be split to done. Stops early if ctx is done.
+func (ccq *colorCutQuantizer) splitBoxes(ctx context.Context, pq *priorityQueue, done []*vbox, maxColors int) ([]*vbox, error) {
+	for pq.Len() > 0 && pq.Len()+len(done) < maxColors {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		v := heap.Pop(pq).(*vbox)
+		if v.CanSplit() {
+			heap.Push(pq, v.Split(ccq.Options.SplitAt, ccq.newVbox()))
+			heap.Push(pq, v)
+		} else {
+			done = append(done, v)
 		}
 	}
+	return done, nil
 }
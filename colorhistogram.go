@@ -1,68 +1,440 @@
 package vibrant
 
 import (
-	"image/color"
+	"context"
+	"image"
+	"math"
 	"sort"
+	"sync"
 )
 
-// Colors and ColorCounts are transformed into a map[int]int by colorCutQuantizer
-// where key is the 24-bit color and value is the count
-type colorHistogram struct {
-	Colors       []int // 24-bit packed int colors
-	ColorCounts  []int // index refers to above color
-	NumberColors int
-}
-
-// See colorCutQuantizer source for how this is used.
-func newColorHistogram(colorPixels []color.Color) *colorHistogram {
-	// Transform []color.Color into array of 24-bit ints
-	pixels := make([]int, len(colorPixels))
-	for _, px := range colorPixels {
-		pixels = append(pixels, packColor(colorToRgb(px)))
-	}
-
-	// Sort the pixels to enable counting
-	sort.Ints(pixels)
-
-	numColors := countDistinctColors(pixels)
-	colors := make([]int, numColors)
-	colorCounts := make([]int, numColors)
-
-	if numColors > 0 {
-		curIndex := 0
-		curColor := pixels[0]
-		colors[0] = curColor
-		colorCounts[0] = 1
-
-		for _, px := range pixels {
-			if px == curColor {
-				// same color, increase population
-				colorCounts[curIndex]++
-			} else {
-				// new color, increase index
-				curColor = px
-				curIndex++
-				colors[curIndex] = curColor
-				colorCounts[curIndex] = 1
-			}
+// Histogram counts are in units of 1/pixelWeight of a pixel, so that pixels
+// can be weighted by a mask (see Options.WeightByMask) without introducing
+// floating point error. HistogramBuilder may scale them, see Histogram.unit.
+const pixelWeight int64 = 0xffff
+
+// A color counts as neutral if its largest and smallest RGB components are
+// at most this far apart, and an image counts as grayscale if at least
+// grayscaleMinFraction of it is neutral. See Histogram.IsGrayscale()
+const (
+	neutralMaxChroma     = 24
+	grayscaleMinFraction = 0.95
+)
+
+// The total count HistogramBuilder scales its Histogram to if it is less
+// than histogramMinTotal or more than histogramMaxTotal, see
+// HistogramBuilder.fill. Large enough that weighting whole images by a
+// fraction of a pixel doesn't lose precision, small enough that the
+// quantizers' sums of count * component don't overflow.
+const (
+	histogramMinTotal = 1 << 40
+	histogramMaxTotal = 1 << 52
+)
+
+// Histogram holds the distinct colors of an image and how often each occurs.
+// It is what a Quantizer reduces to a palette, see also HistogramBuilder.
+type Histogram struct {
+	colors []int   // 24-bit packed int colors, sorted
+	counts []int64 // index refers to above color, see perPixel
+
+	// counts per pixel, 0 means pixelWeight, see HistogramBuilder.fill
+	unit float64
+}
+
+// Returns the count of a single pixel.
+func (h *Histogram) perPixel() float64 {
+	if h.unit == 0 {
+		return float64(pixelWeight)
+	}
+	return h.unit
+}
+
+// Returns a Swatch of color with weight (a count of this Histogram) as its
+// Population, keeping the un-rounded weight for Fraction and for selecting
+// Swatches, see quantize and Target.score.
+func (h *Histogram) newSwatch(color Color, weight int64) Swatch {
+	population := int(math.Floor(float64(weight)/h.perPixel() + 0.5))
+	return Swatch{Color: color, Population: population, weight: weight}
+}
+
+// HistogramBuilder accumulates the colors of several images into a single
+// Histogram, to create one Palette for e.g. all of the covers of an album
+// or the frames of an animation:
+//
+//	hb := vibrant.NewHistogramBuilder(vibrant.DefaultOptions())
+//	for _, img := range frames {
+//		if err := hb.Add(img, 1); err != nil {
+//			...
+//		}
+//	}
+//	palette, err := hb.Palette()
+type HistogramBuilder struct {
+	opts    Options
+	counts  map[int]float64 // every image added so far, see AddContext
+	base    float64         // weight of the first image per counted pixel
+	scratch colorCounters   // for counting a single image
+	image   Histogram       // the colors of the image being added
+
+	histo Histogram // filled from counts when dirty
+	valid Histogram // filtered histo, see quantize
+	dirty bool
+}
+
+// Returns an empty HistogramBuilder which images are added to as
+// configured by opts, see Add. The Palette is created as configured by
+// opts as well.
+func NewHistogramBuilder(opts Options) *HistogramBuilder {
+	return &HistogramBuilder{opts: opts}
+}
+
+// Adds the pixels of img, with every pixel counting as weight pixels, e.g.
+// so that each image counts the same regardless of its size with a weight
+// of 1/(width*height). weight must be greater than 0.
+//
+// img is cropped, masked and scaled down just like by NewPaletteWithOptions,
+// but the pixels of the scaled image count for the pixels they replace, so
+// that the Histogram's Total is that of img.
+func (hb *HistogramBuilder) Add(img image.Image, weight float64) error {
+	return hb.AddContext(context.Background(), img, weight)
+}
+
+// Same as Add, but stops early and returns ctx.Err() if ctx is done, in
+// which case nothing is added.
+func (hb *HistogramBuilder) AddContext(ctx context.Context, img image.Image, weight float64) error {
+	if !(weight > 0) || math.IsInf(weight, 1) {
+		return ErrInvalidWeight
+	}
+	b, err := prepareBitmap(ctx, img, hb.opts)
+	if err != nil {
+		return err
+	}
+	if err := hb.scratch.count(ctx, b, hb.opts.HistogramBits, hb.opts.Parallelism, &hb.image); err != nil {
+		return err
+	}
+
+	area := img.Bounds()
+	if !hb.opts.Region.Empty() {
+		area = area.Intersect(hb.opts.Region)
+	}
+	weight *= float64(area.Dx()*area.Dy()) / float64(b.Width*b.Height)
+
+	// counts are relative to the first image, so that images added with
+	// the same weight are counted exactly (see fill), and not rounded, so
+	// that e.g. a weight of 1/(width*height) doesn't round the counts of
+	// rare colors away
+	if hb.counts == nil {
+		hb.counts = make(map[int]float64)
+		hb.base = weight
+	}
+	for i, c := range hb.image.colors {
+		hb.counts[c] += float64(hb.image.counts[i]) * (weight / hb.base)
+	}
+	// sorting the colors is left until they are needed, so that adding
+	// many images doesn't sort them again every time
+	hb.dirty = true
+	return nil
+}
+
+// Fills hb.histo from the images added so far if any were added since it
+// was last filled.
+//
+// The counts are scaled by a power of two to between histogramMinTotal and
+// histogramMaxTotal in total, so that they keep their precision as
+// integers. Images added with the same weight are counted exactly unless
+// there are very many of them. No color is ever scaled down to 0.
+func (hb *HistogramBuilder) fill() *Histogram {
+	if !hb.dirty {
+		return &hb.histo
+	}
+	h := &hb.histo
+	var total float64
+	h.colors = h.colors[:0]
+	for c, n := range hb.counts {
+		h.colors = append(h.colors, c)
+		total += n
+	}
+	sort.Ints(h.colors)
+
+	scale := 1.0
+	if total < histogramMinTotal {
+		_, exp := math.Frexp(histogramMinTotal / total)
+		scale = math.Ldexp(1, exp)
+	} else if total > histogramMaxTotal {
+		_, exp := math.Frexp(total / histogramMaxTotal)
+		scale = math.Ldexp(1, -exp)
+	}
+	h.counts = h.counts[:0]
+	for _, c := range h.colors {
+		n := int64(math.Floor(hb.counts[c]*scale + 0.5))
+		if n < 1 {
+			n = 1
+		}
+		h.counts = append(h.counts, n)
+	}
+	h.unit = float64(pixelWeight) / hb.base * scale
+	hb.dirty = false
+	return h
+}
+
+// Returns a copy of the Histogram of all of the images added so far,
+// which later calls to Add don't change.
+func (hb *HistogramBuilder) Histogram() *Histogram {
+	h := hb.fill()
+	return &Histogram{
+		colors: append([]int(nil), h.colors...),
+		counts: append([]int64(nil), h.counts...),
+		unit:   h.unit,
+	}
+}
+
+// Creates a Palette from all of the images added so far.
+func (hb *HistogramBuilder) Palette() (Palette, error) {
+	return hb.PaletteContext(context.Background())
+}
+
+// Same as Palette, see NewPaletteContext.
+func (hb *HistogramBuilder) PaletteContext(ctx context.Context) (Palette, error) {
+	return newPaletteFromHistogram(ctx, hb.fill(), &hb.valid, hb.opts)
+}
+
+// colorCounter accumulates pixels one at a time, so that memory use is
+// proportional to the number of distinct colors rather than the number of
+// pixels. See colorCounters.count()
+type colorCounter interface {
+	// Adds a pixel of color (a 24-bit packed int) with weight,
+	// see pixelWeight
+	add(color int, weight int64)
+
+	// Sets h to the Histogram of all pixels added so far, reusing its
+	// slices
+	fill(h *Histogram)
+
+	// Adds all pixels added to other, which was created with the same
+	// arguments to newColorCounter
+	merge(other colorCounter)
+
+	// Removes all pixels, so that the colorCounter can be reused
+	reset()
+}
+
+// colorCounters holds one colorCounter per goroutine of count, which are
+// kept between calls to save allocating them again, see Extractor.
+type colorCounters struct {
+	bits     int
+	counters []colorCounter
+}
+
+// Counts the pixels of b into h with up to workers goroutines, each
+// counting a band of rows with its own colorCounter. Counts are integers,
+// so the result is the same regardless of workers.
+func (cc *colorCounters) count(ctx context.Context, b *bitmap, bits, workers int, h *Histogram) error {
+	bounds := b.Source.Bounds()
+	if workers > bounds.Dy() {
+		workers = bounds.Dy()
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	if bits != cc.bits {
+		cc.bits, cc.counters = bits, nil
+	}
+	for len(cc.counters) < workers {
+		cc.counters = append(cc.counters, newColorCounter(bits))
+	}
+	counters := cc.counters[:workers]
+	for _, counter := range counters {
+		counter.reset()
+	}
+
+	if workers == 1 {
+		if err := b.eachPixel(ctx, bounds.Min.Y, bounds.Max.Y, counters[0].add); err != nil {
+			return err
+		}
+		counters[0].fill(h)
+		return nil
+	}
+
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := range counters {
+		minY := bounds.Min.Y + bounds.Dy()*i/workers
+		maxY := bounds.Min.Y + bounds.Dy()*(i+1)/workers
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = b.eachPixel(ctx, minY, maxY, counters[i].add)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, counter := range counters[1:] {
+		counters[0].merge(counter)
+	}
+	counters[0].fill(h)
+	return nil
+}
+
+// bits is the number of bits per channel colors are reduced to before they
+// are counted, see Options.HistogramBits.
+func newColorCounter(bits int) colorCounter {
+	if bits > 0 && bits < 8 {
+		return newDenseColorCounter(uint(bits))
+	}
+	return sparseColorCounter{}
+}
+
+// Counts exact colors in a map.
+type sparseColorCounter map[int]int64
+
+func (c sparseColorCounter) add(color int, weight int64) {
+	c[color] += weight
+}
+
+func (c sparseColorCounter) merge(other colorCounter) {
+	for color, weight := range other.(sparseColorCounter) {
+		c[color] += weight
+	}
+}
+
+func (c sparseColorCounter) reset() {
+	for color := range c {
+		delete(c, color)
+	}
+}
+
+func (c sparseColorCounter) fill(h *Histogram) {
+	// only the distinct colors are sorted, not every pixel
+	h.colors = h.colors[:0]
+	for color := range c {
+		h.colors = append(h.colors, color)
+	}
+	sort.Ints(h.colors)
+
+	h.counts = h.counts[:0]
+	for _, color := range h.colors {
+		h.counts = append(h.counts, c[color])
+	}
+}
+
+// Counts colors reduced to bits per channel in an array indexed by the
+// reduced color itself, like Android's Palette does with 5 bits (32768
+// bins). Colors come out sorted because the bins are.
+type denseColorCounter struct {
+	bits uint
+	bins []int64
+}
+
+func newDenseColorCounter(bits uint) *denseColorCounter {
+	return &denseColorCounter{bits: bits, bins: make([]int64, 1<<(3*bits))}
+}
+
+func (c *denseColorCounter) add(color int, weight int64) {
+	shift := 8 - c.bits
+	r, g, b := unpackColor(color)
+	c.bins[r>>shift<<(2*c.bits)|g>>shift<<c.bits|b>>shift] += weight
+}
+
+func (c *denseColorCounter) merge(other colorCounter) {
+	for i, weight := range other.(*denseColorCounter).bins {
+		c.bins[i] += weight
+	}
+}
+
+func (c *denseColorCounter) reset() {
+	for i := range c.bins {
+		c.bins[i] = 0
+	}
+}
+
+func (c *denseColorCounter) fill(h *Histogram) {
+	h.colors, h.counts = h.colors[:0], h.counts[:0]
+	bits := c.bits
+	mask := 1<<bits - 1
+	for i, count := range c.bins {
+		if count == 0 {
+			continue
 		}
+		r := expandComponent(i>>(2*bits)&mask, bits)
+		g := expandComponent(i>>bits&mask, bits)
+		b := expandComponent(i&mask, bits)
+		h.colors = append(h.colors, packColor(r, g, b))
+		h.counts = append(h.counts, count)
 	}
+}
 
-	return &colorHistogram{colors, colorCounts, numColors}
+// Scales a component reduced to bits back to the range 0-255, so that
+// e.g. with 5 bits 31 becomes 255 rather than 248.
+func expandComponent(v int, bits uint) int {
+	max := 1<<bits - 1
+	return (v*255 + max/2) / max
 }
 
-func countDistinctColors(pixels []int) int {
-	if len(pixels) < 2 {
-		return len(pixels)
+// Number of distinct colors.
+func (h *Histogram) Len() int {
+	return len(h.colors)
+}
+
+// Returns the i-th color, colors are sorted in ascending order.
+func (h *Histogram) Color(i int) Color {
+	return Color(h.colors[i])
+}
+
+// Returns the number of pixels of the i-th color. This is not necessarily a
+// whole number if pixels were weighted, see Options.WeightByMask and
+// HistogramBuilder.Add.
+func (h *Histogram) Count(i int) float64 {
+	return float64(h.counts[i]) / h.perPixel()
+}
+
+// Returns the total number of pixels, see Count.
+func (h *Histogram) Total() float64 {
+	return float64(h.total()) / h.perPixel()
+}
+
+func (h *Histogram) total() int64 {
+	var sum int64
+	for _, c := range h.counts {
+		sum += c
+	}
+	return sum
+}
+
+// Sets res to the colors allowed by filters, reusing its slices.
+func (h *Histogram) filter(filters []Filter, res *Histogram) *Histogram {
+	res.colors, res.counts = res.colors[:0], res.counts[:0]
+	res.unit = h.unit
+	for i, c := range h.colors {
+		if !shouldIgnoreColor(c, filters) {
+			res.colors = append(res.colors, c)
+			res.counts = append(res.counts, h.counts[i])
+		}
 	}
-	count := 1
-	current := pixels[0]
-	for _, px := range pixels {
-		if px != current {
-			// new color, increase population
-			current = px
-			count++
+	return res
+}
+
+// true if (nearly) all of the colors in this histogram are neutral
+func (h *Histogram) IsGrayscale() bool {
+	var neutral, total int64
+	for i, c := range h.colors {
+		r, g, b := unpackColor(c)
+		max, min := r, r
+		for _, v := range []int{g, b} {
+			if v > max {
+				max = v
+			}
+			if v < min {
+				min = v
+			}
+		}
+		if max-min <= neutralMaxChroma {
+			neutral += h.counts[i]
 		}
+		total += h.counts[i]
 	}
-	return count
+	return total > 0 && float64(neutral) >= grayscaleMinFraction*float64(total)
 }
@@ -0,0 +1,225 @@
+package vibrant
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// Returns the same noisy gradient for the same width and height, with
+// enough distinct colors that reducing them to fewer bits merges some.
+func newTestImage(width, height int) *image.NRGBA {
+	rnd := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(x*255/width) ^ uint8(rnd.Intn(8)),
+				G: uint8(y*255/height) ^ uint8(rnd.Intn(8)),
+				B: uint8(rnd.Intn(256)),
+				A: 0xff,
+			})
+		}
+	}
+	return img
+}
+
+func countColors(t testing.TB, img image.Image, bits, workers int) *Histogram {
+	var cc colorCounters
+	h := &Histogram{}
+	if err := cc.count(context.Background(), newBitmap(img), bits, workers, h); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestColorCountersDenseSparse(t *testing.T) {
+	img := newTestImage(97, 61)
+	sparse := countColors(t, img, 0, 1)
+	dense := countColors(t, img, 5, 1)
+
+	for name, h := range map[string]*Histogram{"sparse": sparse, "dense": dense} {
+		if !sort.IntsAreSorted(h.colors) {
+			t.Errorf("%s: colors are not sorted", name)
+		}
+		if h.total() != int64(97*61)*pixelWeight {
+			t.Errorf("%s: total %d, want %d", name, h.total(), int64(97*61)*pixelWeight)
+		}
+	}
+	if sorted := sortCountColors(img); fmt.Sprint(sorted) != fmt.Sprint(sparse) {
+		t.Errorf("sparse counting differs from sort-based counting")
+	}
+	if dense.Len() >= sparse.Len() {
+		t.Errorf("dense has %d colors, sparse %d", dense.Len(), sparse.Len())
+	}
+
+	// reducing the exact colors to 5 bits has to give the same bins
+	want := map[int]int64{}
+	for i, c := range sparse.colors {
+		r, g, b := unpackColor(c)
+		c = packColor(expandComponent(r>>3, 5), expandComponent(g>>3, 5), expandComponent(b>>3, 5))
+		want[c] += sparse.counts[i]
+	}
+	if len(want) != dense.Len() {
+		t.Fatalf("dense has %d colors, want %d", dense.Len(), len(want))
+	}
+	for i, c := range dense.colors {
+		if dense.counts[i] != want[c] {
+			t.Errorf("%06x: count %d, want %d", c, dense.counts[i], want[c])
+		}
+	}
+}
+
+// The counting colorCounters replaced: every pixel is read with At() into
+// a slice, which is sorted so that runs of the same color can be counted.
+func sortCountColors(img image.Image) *Histogram {
+	bounds := img.Bounds()
+	pixels := make([]color.Color, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pixels = append(pixels, img.At(x, y))
+		}
+	}
+	colors := make([]int, 0, len(pixels))
+	for _, px := range pixels {
+		r, g, b, _ := px.RGBA()
+		colors = append(colors, packColor(int(r>>8), int(g>>8), int(b>>8)))
+	}
+	sort.Ints(colors)
+
+	h := &Histogram{}
+	for i, c := range colors {
+		if i == 0 || c != colors[i-1] {
+			h.colors = append(h.colors, c)
+			h.counts = append(h.counts, 0)
+		}
+		h.counts[len(h.counts)-1] += pixelWeight
+	}
+	return h
+}
+
+func BenchmarkHistogram(b *testing.B) {
+	img := newTestImage(320, 240)
+	b.Run("sort", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			sortCountColors(img)
+		}
+	})
+	// 0 counts exact colors in a map, 5 in 32768 bins
+	for _, bits := range []int{0, 5} {
+		b.Run(fmt.Sprintf("bits=%d", bits), func(b *testing.B) {
+			var cc colorCounters
+			bm := newBitmap(img)
+			h := &Histogram{}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := cc.count(context.Background(), bm, bits, 1, h); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestColorCountersParallel(t *testing.T) {
+	// 61 rows don't divide evenly into 7 bands
+	img := newTestImage(97, 61)
+	for _, bits := range []int{0, 5} {
+		serial := countColors(t, img, bits, 1)
+		parallel := countColors(t, img, bits, 7)
+		if serial.Len() != parallel.Len() {
+			t.Fatalf("bits=%d: %d colors in parallel, want %d", bits, parallel.Len(), serial.Len())
+		}
+		for i := range serial.colors {
+			if serial.colors[i] != parallel.colors[i] || serial.counts[i] != parallel.counts[i] {
+				t.Errorf("bits=%d: %06x x %d in parallel, want %06x x %d", bits,
+					parallel.colors[i], parallel.counts[i], serial.colors[i], serial.counts[i])
+			}
+		}
+	}
+}
+
+func TestHistogramBuilder(t *testing.T) {
+	img := newTestImage(97, 61)
+	opts := DefaultOptions()
+	want, err := NewPaletteWithOptions(img, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hb := NewHistogramBuilder(opts)
+	if _, err := hb.Palette(); err != ErrNoUsableColors {
+		t.Errorf("empty: got %v, want %v", err, ErrNoUsableColors)
+	}
+	if err := hb.Add(img, 0); err != ErrInvalidWeight {
+		t.Errorf("weight 0: got %v, want %v", err, ErrInvalidWeight)
+	}
+	if err := hb.Add(img, 1); err != nil {
+		t.Fatal(err)
+	}
+	got, err := hb.Palette()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got.Swatches()) != fmt.Sprint(want.Swatches()) {
+		t.Errorf("got %v, want %v", got.Swatches(), want.Swatches())
+	}
+
+	// the copy doesn't change when more images are added
+	h := hb.Histogram()
+	total := h.Total()
+	if total != 97*61 {
+		t.Errorf("Total %v, want %v", total, 97*61)
+	}
+	if err := hb.Add(img, 0.5); err != nil {
+		t.Fatal(err)
+	}
+	if h.Total() != total {
+		t.Errorf("Histogram changed after Add")
+	}
+	if got := hb.Histogram().Total(); got != total*1.5 {
+		t.Errorf("Total %v after Add, want %v", got, total*1.5)
+	}
+}
+
+func TestHistogramBuilderSmallWeight(t *testing.T) {
+	img := newTestImage(400, 300)
+	opts := DefaultOptions()
+	whole := NewHistogramBuilder(opts)
+	if err := whole.Add(img, 1); err != nil {
+		t.Fatal(err)
+	}
+	// every image counts as a single pixel in total
+	small := NewHistogramBuilder(opts)
+	if err := small.Add(img, 1/float64(400*300)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := small.Histogram().Len(), whole.Histogram().Len(); got != want {
+		t.Errorf("%d colors, want %d", got, want)
+	}
+	if total := small.Histogram().Total(); math.Abs(total-1) > 1e-9 {
+		t.Errorf("Total %v, want 1", total)
+	}
+	got, err := small.Palette()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := whole.Palette()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got.Swatches()) != fmt.Sprint(want.Swatches()) {
+		t.Errorf("Swatches: got %v, want %v", got.Swatches(), want.Swatches())
+	}
+	if g, w := resultsString(got.ExtractAwesomeOrdered()), resultsString(want.ExtractAwesomeOrdered()); g != w {
+		t.Errorf("ExtractAwesomeOrdered: got %s, want %s", g, w)
+	}
+}
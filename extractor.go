@@ -0,0 +1,123 @@
+package vibrant
+
+import (
+	"context"
+	"image"
+)
+
+// An Extractor creates Palettes like NewPaletteWithOptions, but keeps the
+// buffers it needs for counting colors between calls, so that creating
+// many Palettes doesn't allocate them again every time. Together with the
+// pooled buffers of MedianCutQuantizer, repeated calls mostly allocate
+// the Palettes themselves and, if images are scaled down, the scaled
+// images.
+//
+// An Extractor must not be used by more than one goroutine at a time,
+// create one per goroutine instead.
+//
+//	e := vibrant.NewExtractor(vibrant.DefaultOptions())
+//	for _, img := range images {
+//		palette, err := e.Extract(img)
+//		...
+//	}
+type Extractor struct {
+	// Used by every call to Extract, may be changed in between.
+	Options Options
+
+	counters colorCounters
+	histo    Histogram
+	valid    Histogram // filtered histo, see quantize
+}
+
+func NewExtractor(opts Options) *Extractor {
+	return &Extractor{Options: opts}
+}
+
+// Creates a Palette from img as configured by Options.
+func (e *Extractor) Extract(img image.Image) (Palette, error) {
+	return e.ExtractContext(context.Background(), img)
+}
+
+// Same as Extract, but stops early and returns ctx.Err() if ctx is done,
+// e.g. to enforce a deadline per request in an HTTP handler.
+//
+// ctx is checked between the steps of creating a Palette, for every row of
+// pixels counted, and within the Quantizer if it is a ContextQuantizer.
+// Scaling the image down can't be interrupted, but is no longer waited for
+// once ctx is done.
+func (e *Extractor) ExtractContext(ctx context.Context, img image.Image) (Palette, error) {
+	opts := e.Options
+	if opts.MaximumColorCount < 1 {
+		return Palette{}, ErrInvalidColorCount
+	}
+	b, err := prepareBitmap(ctx, img, opts)
+	if err != nil {
+		return Palette{}, err
+	}
+	histo := &e.histo
+	if err := e.counters.count(ctx, b, opts.HistogramBits, opts.Parallelism, histo); err != nil {
+		return Palette{}, err
+	}
+	return newPaletteFromHistogram(ctx, histo, &e.valid, opts)
+}
+
+// Returns img as a bitmap, cropped, masked and scaled down as configured
+// by opts.
+func prepareBitmap(ctx context.Context, img image.Image, opts Options) (*bitmap, error) {
+	if opts.HistogramBits < 0 || opts.HistogramBits > 8 {
+		return nil, ErrInvalidHistogramBits
+	}
+	if img == nil || img.Bounds().Empty() {
+		return nil, ErrEmptyImage
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b := newBitmap(img)
+	b.AlphaThreshold = opts.AlphaThreshold
+	b.Background = opts.Background
+	if opts.Mask != nil {
+		b.setMask(opts.Mask, opts.WeightByMask)
+	}
+	if !opts.Region.Empty() {
+		if err := b.crop(opts.Region); err != nil {
+			return nil, err
+		}
+	}
+	return b.scaleDownContext(ctx, opts.ResizeBitmapArea, opts.Resampler, opts.LinearLight)
+}
+
+// Quantizes histo into a Palette as configured by opts, using valid for
+// the colors allowed by the filters, see quantize.
+func newPaletteFromHistogram(ctx context.Context, histo, valid *Histogram, opts Options) (Palette, error) {
+	var p Palette
+	if opts.MaximumColorCount < 1 {
+		return p, ErrInvalidColorCount
+	}
+	if histo.Len() == 0 {
+		return p, ErrNoUsableColors
+	}
+
+	filters, targets := opts.Filters, opts.Targets
+	if histo.IsGrayscale() && len(opts.GrayscaleTargets) > 0 {
+		p.grayscale = true
+		filters, targets = opts.GrayscaleFilters, opts.GrayscaleTargets
+	}
+
+	swatches, err := quantize(ctx, opts.Quantizer, histo, valid, opts.MaximumColorCount, filters)
+	if err != nil {
+		return p, err
+	}
+	if len(swatches) == 0 {
+		return p, ErrNoUsableColors
+	}
+	p.swatches = swatches
+	// copied so that changing a Target afterwards doesn't change the Palette
+	p.targets = copyTargets(targets)
+	for _, sw := range swatches {
+		if sw.weight > p.highestWeight {
+			p.highestWeight = sw.weight
+		}
+	}
+	return p, nil
+}
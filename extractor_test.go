@@ -0,0 +1,131 @@
+package vibrant
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// An image whose pixels can't be read until release is closed. started
+// is closed once the first pixel is read.
+type blockingImage struct {
+	image.Image
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (b *blockingImage) At(x, y int) color.Color {
+	b.once.Do(func() { close(b.started) })
+	<-b.release
+	return b.Image.At(x, y)
+}
+
+func TestExtractContextCancelResize(t *testing.T) {
+	before := runtime.NumGoroutine()
+	img := &blockingImage{
+		Image:   newTestImage(400, 300),
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := NewExtractor(DefaultOptions()).ExtractContext(ctx, img)
+		errc <- err
+	}()
+	// only cancel once the resize is under way
+	<-img.started
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+
+	// the abandoned resize finishes in the background, which must not
+	// overlap with other tests, e.g. TestExtractorAllocs
+	close(img.release)
+	waitForGoroutines(t, before)
+}
+
+func BenchmarkNewPaletteWithOptions(b *testing.B) {
+	img := newTestImage(400, 300)
+	opts := DefaultOptions()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewPaletteWithOptions(img, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkExtractorExtract(b *testing.B) {
+	img := newTestImage(400, 300)
+	e := NewExtractor(DefaultOptions())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := e.Extract(img); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestExtractorAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations are not reliable with the race detector")
+	}
+	img := newTestImage(100, 80)
+	opts := DefaultOptions()
+	opts.ResizeBitmapArea = -1
+	e := NewExtractor(opts)
+	n := testing.AllocsPerRun(20, func() {
+		if _, err := e.Extract(img); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// the Palette itself and its Swatches, but not the color counters
+	// and histograms, which are reused
+	if n > 20 {
+		t.Errorf("%v allocations per Extract, want at most 20", n)
+	}
+}
+
+// Formats the name of every Target with the Swatch it was matched with.
+func resultsString(results []Result) string {
+	var s string
+	for _, r := range results {
+		s += fmt.Sprintf("%s: %v\n", r.Target.Name, r.Swatch)
+	}
+	return s
+}
+
+func TestWeightByMaskUniform(t *testing.T) {
+	img := newTestImage(400, 300)
+	opts := DefaultOptions()
+	want, err := NewPaletteWithOptions(img, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every pixel counts as 3/255 of a pixel, which rounds every
+	// Population to 0 or 1 but must not change which Swatches are picked
+	mask := image.NewUniform(color.Gray{3})
+	opts.Mask = &croppedImage{mask, img.Bounds()}
+	opts.WeightByMask = true
+	got, err := NewPaletteWithOptions(img, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got.Swatches()) != fmt.Sprint(want.Swatches()) {
+		t.Errorf("Swatches: got %v, want %v", got.Swatches(), want.Swatches())
+	}
+	if g, w := resultsString(got.ExtractAwesomeOrdered()), resultsString(want.ExtractAwesomeOrdered()); g != w {
+		t.Errorf("ExtractAwesomeOrdered: got %s, want %s", g, w)
+	}
+}
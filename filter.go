@@ -0,0 +1,86 @@
+package vibrant
+
+import "math"
+
+const (
+	blackMaxLightness float64 = 0.05
+	whiteMinLightness float64 = 0.95
+)
+
+// A Filter decides whether a color is allowed into the palette.
+//
+// Filters are applied to every color in the Histogram before
+// quantization and to every quantized Swatch afterwards. A color is only
+// used if every Filter allows it.
+type Filter interface {
+	// rgb is the 24-bit color, h, s and l are its hue, saturation and
+	// lightness components in the range 0-1
+	IsAllowed(rgb Color, h, s, l float64) bool
+}
+
+// FilterFunc is an adapter to allow the use of ordinary functions as Filters.
+type FilterFunc func(rgb Color, h, s, l float64) bool
+
+func (f FilterFunc) IsAllowed(rgb Color, h, s, l float64) bool {
+	return f(rgb, h, s, l)
+}
+
+// Rejects colors close to pure black or pure white.
+var BlackWhiteFilter Filter = FilterFunc(func(rgb Color, h, s, l float64) bool {
+	return l > blackMaxLightness && l < whiteMinLightness
+})
+
+// Rejects "the red side of the I line" which I believe is Google-speak for
+// "that particular shade of red which occurs in the red-eye effect"
+// see enwp.org/Red-eye_effect
+var RedEyeFilter Filter = FilterFunc(func(rgb Color, h, s, l float64) bool {
+	return !(h >= 0.0278 && h <= 0.1028 && s <= 0.82)
+})
+
+// The Filter used by NewPalette, the same as
+//
+//	ChainFilters(BlackWhiteFilter, RedEyeFilter)
+var DefaultFilter Filter = ChainFilters(BlackWhiteFilter, RedEyeFilter)
+
+type chainFilter []Filter
+
+func (c chainFilter) IsAllowed(rgb Color, h, s, l float64) bool {
+	for _, f := range c {
+		if !f.IsAllowed(rgb, h, s, l) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a Filter which only allows colors allowed by every one of filters.
+func ChainFilters(filters ...Filter) Filter {
+	return chainFilter(append([]Filter(nil), filters...))
+}
+
+// Returns a Filter which rejects near-gray colors, i.e. colors with a
+// saturation less than or equal to maxSaturation.
+func NewNeutralFilter(maxSaturation float64) Filter {
+	return FilterFunc(func(rgb Color, h, s, l float64) bool {
+		return s > maxSaturation
+	})
+}
+
+// Returns a Filter which rejects colors within maxDistance of any of colors,
+// e.g. to exclude a known watermark or background.
+//
+// Distance is euclidean in RGB space, where components are in the range 0-255.
+// A maxDistance of 0 only rejects exact matches.
+func NewColorListFilter(maxDistance float64, colors ...Color) Filter {
+	colors = append([]Color(nil), colors...)
+	return FilterFunc(func(rgb Color, h, s, l float64) bool {
+		r1, g1, b1 := unpackColorFloat(int(rgb))
+		for _, c := range colors {
+			r2, g2, b2 := unpackColorFloat(int(c))
+			if math.Sqrt((r1-r2)*(r1-r2)+(g1-g2)*(g1-g2)+(b1-b2)*(b1-b2)) <= maxDistance {
+				return false
+			}
+		}
+		return true
+	})
+}
@@ -19,7 +19,7 @@ https://github.com/jariz/vibrant.js
 	checkErr(err)
 
 	for name, swatch := range palette.ExtractAwesome() {
-		fmt.Printf("/* %s (population: %d) *\/\n%s\n\n", name, swatch.Population, swatch)
+		fmt.Printf("/* %s (population: %d) *\/\n%s\n\n", name, swatch.MatchedPopulation, swatch)
 	}
 
 output:
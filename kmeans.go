@@ -0,0 +1,157 @@
+package vibrant
+
+import (
+	"context"
+	"math/rand"
+)
+
+// Defaults for KMeansQuantizer.
+const (
+	DEFAULT_KMEANS_ITERATIONS  = 10
+	DEFAULT_KMEANS_CONVERGENCE = 0.001
+)
+
+// KMeansQuantizer refines the Swatches of another Quantizer with weighted
+// k-means clustering in the OKLab color space, similar to Material's
+// QuantizerWsmeans.
+//
+// The Swatches of Initial are used as the starting cluster centers. Every
+// color in the Histogram is then repeatedly assigned to the nearest center,
+// weighted by its count, and every center is moved to the weighted mean of
+// its colors. Because OKLab is perceptually uniform, the resulting colors
+// are less muddy than averages in sRGB.
+//
+//	opts.Quantizer = vibrant.KMeansQuantizer{Initial: vibrant.WuQuantizer}
+type KMeansQuantizer struct {
+	// Provides the starting cluster centers, nil is the same as
+	// MedianCutQuantizer.
+	Initial Quantizer
+
+	// Maximum number of iterations, 0 is the same as
+	// DEFAULT_KMEANS_ITERATIONS.
+	MaxIterations int
+
+	// Stop iterating once no cluster center moves more than this
+	// (euclidean distance in OKLab), 0 is the same as
+	// DEFAULT_KMEANS_CONVERGENCE.
+	Convergence float64
+
+	// If Initial returns fewer than maxColors Swatches, the remaining
+	// centers are picked at random from the Histogram using this seed,
+	// so the result is always the same for the same input.
+	Seed int64
+}
+
+type oklabPoint struct {
+	L, a, b float64
+}
+
+func (p oklabPoint) distanceSquared(q oklabPoint) float64 {
+	dL, da, db := p.L-q.L, p.a-q.a, p.b-q.b
+	return dL*dL + da*da + db*db
+}
+
+func (k KMeansQuantizer) Quantize(histo *Histogram, maxColors int) []*Swatch {
+	swatches, _ := k.QuantizeContext(context.Background(), histo, maxColors)
+	return swatches
+}
+
+func (k KMeansQuantizer) QuantizeContext(ctx context.Context, histo *Histogram, maxColors int) ([]*Swatch, error) {
+	initial := k.Initial
+	if initial == nil {
+		initial = MedianCutQuantizer
+	}
+	iterations := k.MaxIterations
+	if iterations <= 0 {
+		iterations = DEFAULT_KMEANS_ITERATIONS
+	}
+	convergence := k.Convergence
+	if convergence <= 0 {
+		convergence = DEFAULT_KMEANS_CONVERGENCE
+	}
+
+	points := make([]oklabPoint, histo.Len())
+	for i, c := range histo.colors {
+		L, a, b := rgbToOklab(c)
+		points[i] = oklabPoint{L, a, b}
+	}
+
+	initialSwatches, err := quantizeContext(ctx, initial, histo, maxColors)
+	if err != nil {
+		return nil, err
+	}
+	centers := make([]oklabPoint, 0, maxColors)
+	for _, sw := range initialSwatches {
+		if len(centers) == maxColors {
+			break
+		}
+		L, a, b := rgbToOklab(int(sw.Color))
+		centers = append(centers, oklabPoint{L, a, b})
+	}
+	if len(centers) < maxColors && len(points) > 0 {
+		rnd := rand.New(rand.NewSource(k.Seed))
+		for len(centers) < maxColors {
+			centers = append(centers, points[rnd.Intn(len(points))])
+		}
+	}
+
+	assignments := make([]int, len(points))
+	weights := make([]int64, len(centers))
+	for iter := 0; iter < iterations; iter++ {
+		for i, p := range points {
+			// every color is compared to every center, so check ctx
+			// every now and then rather than once per iteration
+			if i%1024 == 0 {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+			}
+			nearest, min := 0, p.distanceSquared(centers[0])
+			for j := 1; j < len(centers); j++ {
+				if d := p.distanceSquared(centers[j]); d < min {
+					nearest, min = j, d
+				}
+			}
+			assignments[i] = nearest
+		}
+
+		sums := make([]oklabPoint, len(centers))
+		for j := range weights {
+			weights[j] = 0
+		}
+		for i, p := range points {
+			j, w := assignments[i], histo.counts[i]
+			fw := float64(w)
+			sums[j].L += p.L * fw
+			sums[j].a += p.a * fw
+			sums[j].b += p.b * fw
+			weights[j] += w
+		}
+
+		moved := 0.0
+		for j := range centers {
+			if weights[j] == 0 {
+				// empty cluster, leave the center where it is
+				continue
+			}
+			fw := float64(weights[j])
+			c := oklabPoint{sums[j].L / fw, sums[j].a / fw, sums[j].b / fw}
+			if d := c.distanceSquared(centers[j]); d > moved {
+				moved = d
+			}
+			centers[j] = c
+		}
+		if moved <= convergence*convergence {
+			break
+		}
+	}
+
+	swatches := make([]*Swatch, 0, len(centers))
+	for j, c := range centers {
+		if weights[j] > 0 {
+			sw := histo.newSwatch(Color(oklabToRgb(c.L, c.a, c.b)), weights[j])
+			swatches = append(swatches, &sw)
+		}
+	}
+	return swatches, nil
+}
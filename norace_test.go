@@ -0,0 +1,5 @@
+//go:build !race
+
+package vibrant
+
+const raceEnabled = false
@@ -0,0 +1,135 @@
+package vibrant
+
+import (
+	"context"
+	"sort"
+)
+
+// Each level of the octree splits the color space in half along every
+// RGB component, so leaves at octreeDepth hold exactly one 24-bit color.
+const octreeDepth = 8
+
+type octreeNode struct {
+	children [8]*octreeNode
+	// sums of the color components, weighted by count, and the sum of
+	// counts of every color in this subtree, see pixelWeight
+	red, green, blue int64
+	weight           int64
+	leaf             bool
+}
+
+// Returns the index of the child of a node at level which contains the
+// color r, g, b.
+func octreeChildIndex(r, g, b, level int) int {
+	shift := uint(7 - level)
+	return (r>>shift&1)<<2 | (g>>shift&1)<<1 | (b >> shift & 1)
+}
+
+type octreeQuantizer struct{}
+
+func (q octreeQuantizer) Quantize(histo *Histogram, maxColors int) []*Swatch {
+	swatches, _ := q.QuantizeContext(context.Background(), histo, maxColors)
+	return swatches
+}
+
+func (octreeQuantizer) QuantizeContext(ctx context.Context, histo *Histogram, maxColors int) ([]*Swatch, error) {
+	root := &octreeNode{}
+	// reducible[level] holds the nodes at level with children,
+	// in the order they were created
+	reducible := make([][]*octreeNode, octreeDepth)
+	leaves := 0
+
+	for i, c := range histo.colors {
+		r, g, b := unpackColor(c)
+		w := histo.counts[i]
+		node := root
+		for level := 0; ; level++ {
+			node.red += int64(r) * w
+			node.green += int64(g) * w
+			node.blue += int64(b) * w
+			node.weight += w
+			if level == octreeDepth {
+				if !node.leaf {
+					node.leaf = true
+					leaves++
+				}
+				break
+			}
+			idx := octreeChildIndex(r, g, b, level)
+			if node.children[idx] == nil {
+				if node.isEmpty() {
+					reducible[level] = append(reducible[level], node)
+				}
+				node.children[idx] = &octreeNode{}
+			}
+			node = node.children[idx]
+		}
+	}
+
+	// Merge the least populated nodes into their parents, deepest first,
+	// until there are few enough leaves. Merging a node does not change
+	// the weight of any other node on the same level, so each level only
+	// has to be sorted once.
+	for level := octreeDepth - 1; level >= 0 && leaves > maxColors; level-- {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		nodes := reducible[level]
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return nodes[i].weight < nodes[j].weight
+		})
+		for _, node := range nodes {
+			if leaves <= maxColors {
+				break
+			}
+			leaves -= node.reduce() - 1
+		}
+	}
+
+	swatches := make([]*Swatch, 0, leaves)
+	root.collect(histo, &swatches)
+	return swatches, nil
+}
+
+// true if this node has no children
+func (n *octreeNode) isEmpty() bool {
+	for _, child := range n.children {
+		if child != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Turns this node into a leaf, returns the number of leaves it replaced.
+func (n *octreeNode) reduce() int {
+	merged := 0
+	for i, child := range n.children {
+		if child != nil {
+			merged++
+			n.children[i] = nil
+		}
+	}
+	n.leaf = true
+	return merged
+}
+
+// Appends the average color of every leaf below n to swatches, see
+// Histogram.newSwatch.
+func (n *octreeNode) collect(histo *Histogram, swatches *[]*Swatch) {
+	if n.leaf {
+		if n.weight > 0 {
+			r := round(float64(n.red) / float64(n.weight))
+			g := round(float64(n.green) / float64(n.weight))
+			b := round(float64(n.blue) / float64(n.weight))
+			sw := histo.newSwatch(Color(packColor(r, g, b)), n.weight)
+			*swatches = append(*swatches, &sw)
+		}
+		return
+	}
+	for _, child := range n.children {
+		if child != nil {
+			child.collect(histo, swatches)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package vibrant
+
+import (
+	"context"
+	"image"
+	"image/color"
+)
+
+// Options for creating a Palette, see also Builder.
+type Options struct {
+	// Maximum number of colors in the quantized palette, see NewPalette.
+	MaximumColorCount int
+
+	// If greater than 0, images with a larger area (width * height) are
+	// scaled down to approximately this many pixels before processing.
+	//
+	// If 0, images are scaled down so that their shorter side is
+	// calculateBitmapMinDimension pixels, which is what NewPalette does.
+	// If less than 0, images are never scaled down.
+	ResizeBitmapArea int
+
+	// How images are scaled down, see ResizeBitmapArea.
+	Resampler Resampler
+
+	// If true, the image is scaled down in linear light rather than sRGB,
+	// so that e.g. fine black and white stripes become a mid gray instead
+	// of a dark one. Use together with MedianCut.LinearLight to average
+	// colors in linear light throughout.
+	LinearLight bool
+
+	// If not empty, only the pixels within this rectangle are used.
+	//
+	// Coordinates are in the same coordinate space as img.Bounds() (not
+	// offset by Bounds().Min), regardless of any scaling. The image is
+	// cropped before it is scaled, so ResizeBitmapArea applies to the size
+	// of the region. It is an error (ErrEmptyRegion) if Region does not
+	// intersect the image.
+	Region image.Rectangle
+
+	// Pixels with an alpha value (in the range 0-255) below this are
+	// ignored, so e.g. the transparent background of a logo does not
+	// count as black. Fully transparent pixels are always ignored unless
+	// a Background is set, so 0 uses every other pixel.
+	AlphaThreshold uint8
+
+	// If not nil, partially transparent pixels are composited over this
+	// color. Otherwise their alpha is simply removed, i.e. a half
+	// transparent red pixel counts as red.
+	Background color.Color
+
+	// If not nil, only pixels where Mask is at least half opaque (or
+	// half white, for grayscale masks) are used, e.g. a foreground
+	// cut-out. Mask is in the same coordinate space as the source image,
+	// pixels outside of its bounds are masked out.
+	Mask image.Image
+
+	// If true, every pixel counts as much as Mask's intensity at that
+	// point instead, i.e. a half transparent point in Mask only counts
+	// as half a pixel.
+	WeightByMask bool
+
+	// Number of bits per RGB channel colors are reduced to before they
+	// are counted. 1-7 count colors in an array with 2^(3*bits) bins, which
+	// is faster than 0 or 8 (exact colors counted in a map) but merges
+	// similar colors: Android's Palette uses 5.
+	HistogramBits int
+
+	// Number of goroutines counting the colors of the image, each in its
+	// own band of rows. 0 or 1 counts them on the calling goroutine.
+	// The result is the same either way, so this is only worth it for
+	// large images, e.g. if ResizeBitmapArea is very large.
+	Parallelism int
+
+	// Reduces the colors of the image to at most MaximumColorCount,
+	// nil is the same as MedianCutQuantizer.
+	Quantizer Quantizer
+
+	// Colors which are not allowed by every Filter are ignored.
+	Filters []Filter
+
+	// Targets searched for by ExtractAwesome, in order.
+	Targets []*Target
+
+	// Used instead of Filters and Targets if the image is (effectively)
+	// grayscale, see Palette.IsGrayscale. If GrayscaleTargets is empty,
+	// grayscale images are treated like any other.
+	GrayscaleFilters []Filter
+	GrayscaleTargets []*Target
+}
+
+// Returns the Options used by NewPaletteFromImage.
+func DefaultOptions() Options {
+	return Options{
+		MaximumColorCount: DEFAULT_CALCULATE_NUMBER_COLORS,
+		AlphaThreshold:    DEFAULT_ALPHA_THRESHOLD,
+		Quantizer:         MedianCutQuantizer,
+		Filters:           []Filter{DefaultFilter},
+		Targets:           DefaultTargets(),
+		GrayscaleTargets:  NeutralTargets(),
+	}
+}
+
+// Builder provides a fluent interface for Options, modelled after
+// Android's Palette.Builder:
+//
+//	palette, err := vibrant.NewBuilder(img).
+//		MaximumColorCount(32).
+//		ClearFilters().
+//		Generate()
+type Builder struct {
+	img  image.Image
+	opts Options
+}
+
+// Returns a Builder for img initialized with DefaultOptions().
+func NewBuilder(img image.Image) *Builder {
+	return &Builder{img: img, opts: DefaultOptions()}
+}
+
+func (b *Builder) MaximumColorCount(n int) *Builder {
+	b.opts.MaximumColorCount = n
+	return b
+}
+
+func (b *Builder) ResizeBitmapArea(area int) *Builder {
+	b.opts.ResizeBitmapArea = area
+	return b
+}
+
+func (b *Builder) Resampler(r Resampler) *Builder {
+	b.opts.Resampler = r
+	return b
+}
+
+func (b *Builder) LinearLight(enabled bool) *Builder {
+	b.opts.LinearLight = enabled
+	return b
+}
+
+func (b *Builder) SetRegion(region image.Rectangle) *Builder {
+	b.opts.Region = region
+	return b
+}
+
+func (b *Builder) ClearRegion() *Builder {
+	b.opts.Region = image.Rectangle{}
+	return b
+}
+
+func (b *Builder) AlphaThreshold(threshold uint8) *Builder {
+	b.opts.AlphaThreshold = threshold
+	return b
+}
+
+func (b *Builder) Background(c color.Color) *Builder {
+	b.opts.Background = c
+	return b
+}
+
+// weighted sets Options.WeightByMask
+func (b *Builder) SetMask(mask image.Image, weighted bool) *Builder {
+	b.opts.Mask = mask
+	b.opts.WeightByMask = weighted
+	return b
+}
+
+func (b *Builder) ClearMask() *Builder {
+	b.opts.Mask = nil
+	b.opts.WeightByMask = false
+	return b
+}
+
+func (b *Builder) HistogramBits(bits int) *Builder {
+	b.opts.HistogramBits = bits
+	return b
+}
+
+func (b *Builder) Parallelism(n int) *Builder {
+	b.opts.Parallelism = n
+	return b
+}
+
+func (b *Builder) Quantizer(q Quantizer) *Builder {
+	b.opts.Quantizer = q
+	return b
+}
+
+func (b *Builder) AddFilter(f Filter) *Builder {
+	b.opts.Filters = append(b.opts.Filters, f)
+	return b
+}
+
+func (b *Builder) ClearFilters() *Builder {
+	b.opts.Filters = nil
+	return b
+}
+
+func (b *Builder) AddTarget(t *Target) *Builder {
+	b.opts.Targets = append(b.opts.Targets, t)
+	return b
+}
+
+func (b *Builder) ClearTargets() *Builder {
+	b.opts.Targets = nil
+	return b
+}
+
+// Sets Options.GrayscaleFilters and Options.GrayscaleTargets, pass no
+// targets to treat grayscale images like any other.
+func (b *Builder) Grayscale(filters []Filter, targets []*Target) *Builder {
+	b.opts.GrayscaleFilters = filters
+	b.opts.GrayscaleTargets = targets
+	return b
+}
+
+// Returns a copy of the Options configured so far.
+func (b *Builder) Options() Options {
+	opts := b.opts
+	opts.Filters = append([]Filter(nil), b.opts.Filters...)
+	opts.Targets = append([]*Target(nil), b.opts.Targets...)
+	opts.GrayscaleFilters = append([]Filter(nil), b.opts.GrayscaleFilters...)
+	opts.GrayscaleTargets = append([]*Target(nil), b.opts.GrayscaleTargets...)
+	return opts
+}
+
+// Creates the Palette, see NewPaletteWithOptions.
+func (b *Builder) Generate() (Palette, error) {
+	return NewPaletteWithOptions(b.img, b.Options())
+}
+
+// Same as Generate, but stops early if ctx is done, see NewPaletteContext.
+func (b *Builder) GenerateContext(ctx context.Context) (Palette, error) {
+	return NewPaletteContext(ctx, b.img, b.Options())
+}
@@ -1,6 +1,7 @@
 package vibrant
 
 import (
+	"context"
 	"errors"
 	"image"
 	"math"
@@ -11,6 +12,7 @@ import (
 const (
 	calculateBitmapMinDimension     = 100
 	DEFAULT_CALCULATE_NUMBER_COLORS = 256
+	DEFAULT_ALPHA_THRESHOLD         = 128
 	TARGET_DARK_LUMA                = 0.26
 	MAX_DARK_LUMA                   = 0.45
 	MIN_LIGHT_LUMA                  = 0.55
@@ -29,11 +31,31 @@ const (
 	MIN_CONTRAST_BODY_TEXT          = 4.5
 )
 
+// Errors returned by NewPalette and friends.
+var (
+	ErrInvalidColorCount    = errors.New("numColors must be 1 or greater")
+	ErrInvalidHistogramBits = errors.New("HistogramBits must be between 0 and 8")
+	ErrInvalidWeight        = errors.New("weight must be greater than 0")
+
+	// The image has no pixels at all.
+	ErrEmptyImage = errors.New("image is empty")
+
+	// Options.Region does not intersect the image.
+	ErrEmptyRegion = errors.New("region does not intersect the image")
+
+	// Every pixel of the image was ignored as transparent or masked out,
+	// or every color was filtered out, see Options.Filters.
+	ErrNoUsableColors = errors.New("image has no usable colors")
+)
+
+// A Palette is never modified after it is created, so it can be cached,
+// queried repeatedly and shared between goroutines.
 type Palette struct {
 	// Contains the quantized palette for a given source image
-	swatches          []*Swatch
-	highestPopulation int
-	selected          []*Swatch
+	swatches      []*Swatch
+	highestWeight int64 // see Swatch.weight
+	targets       []*Target
+	grayscale     bool
 }
 
 // Calls NewPalette with DEFAULT_CALCULATE_NUMBER_COLORS as a default value for numColors.
@@ -41,6 +63,8 @@ func NewPaletteFromImage(img image.Image) (Palette, error) {
 	return NewPalette(img, DEFAULT_CALCULATE_NUMBER_COLORS)
 }
 
+// Creates a Palette from img using the default filter and targets, with
+// numColors as the maximum number of colors in the quantized palette.
 func NewPalette(img image.Image, numColors int) (Palette, error) {
 	// The original comments in the Android source suggest using a number
 	// between 12 and 32, however this almost always results in too few colors
@@ -50,33 +74,30 @@ func NewPalette(img image.Image, numColors int) (Palette, error) {
 	// satisfactory. There is a minor (almost negligible) performance hit for
 	// high numColors values when calling ExtractAwesome(), however.
 	//
-	// A numColors above the number of validColors found in the colorHistogram
+	// A numColors above the number of validColors found in the Histogram
 	// will skip the quantization step outright.
 	//
-	// See also source code for colorCutQuantizer, vbox, and colorHistogram
+	// See also source code for colorCutQuantizer, vbox, and Histogram
+	opts := DefaultOptions()
+	opts.MaximumColorCount = numColors
+	return NewPaletteWithOptions(img, opts)
+}
 
-	b := newBitmap(img)
-	var p Palette
-	if numColors < 1 {
-		return p, errors.New("numColors must be 1 or greater")
-	}
-	minDim := math.Min(float64(b.Width), float64(b.Height))
-	if minDim > calculateBitmapMinDimension {
-		scaleRatio := calculateBitmapMinDimension / minDim
-		b = newScaledBitmap(b.Source, scaleRatio)
-	}
-	ccq := newColorCutQuantizer(*b, numColors)
-	swatches := ccq.QuantizedColors
-	p.swatches = swatches
-	var population float64 = 0
-	for _, sw := range swatches {
-		population = math.Max(population, float64(sw.Population))
-	}
-	p.highestPopulation = int(population)
-	return p, nil
+// Creates a Palette from img as configured by opts, see also Builder.
+func NewPaletteWithOptions(img image.Image, opts Options) (Palette, error) {
+	return NewPaletteContext(context.Background(), img, opts)
 }
 
-// Possible map keys are:
+// Same as NewPaletteWithOptions, but stops early and returns ctx.Err() if
+// ctx is done, e.g. to enforce a deadline per request in an HTTP handler.
+//
+// See Extractor.ExtractContext.
+func NewPaletteContext(ctx context.Context, img image.Image, opts Options) (Palette, error) {
+	return NewExtractor(opts).ExtractContext(ctx, img)
+}
+
+// Possible map keys are the Names of the Targets the Palette was created
+// with, which by default are:
 //
 //	Vibrant
 //	LightVibrant
@@ -85,91 +106,197 @@ func NewPalette(img image.Image, numColors int) (Palette, error) {
 //	LightMuted
 //	DarkMuted
 //
+// or, if the image is grayscale (see IsGrayscale):
+//
+//	Black
+//	Gray
+//	White
+//
 // Some or all of these keys might not be set depending on
 // the source image and numColors parameters used when creating the Palette. YMMV
+//
+// Map iteration order is random, see ExtractAwesomeOrdered.
 func (p *Palette) ExtractAwesome() map[string]*Swatch {
-	profiles := map[string][]float64{
-		"Vibrant":      {TARGET_NORMAL_LUMA, MIN_NORMAL_LUMA, MAX_NORMAL_LUMA, TARGET_VIBRANT_SATURATION, MIN_VIBRANT_SATURATION, 1},
-		"LightVibrant": {TARGET_LIGHT_LUMA, MIN_LIGHT_LUMA, 1, TARGET_VIBRANT_SATURATION, MIN_VIBRANT_SATURATION, 1},
-		"DarkVibrant":  {TARGET_DARK_LUMA, 0, MAX_DARK_LUMA, TARGET_VIBRANT_SATURATION, MIN_VIBRANT_SATURATION, 1},
-		"Muted":        {TARGET_NORMAL_LUMA, MIN_NORMAL_LUMA, MAX_NORMAL_LUMA, TARGET_MUTED_SATURATION, 0, MAX_MUTED_SATURATION},
-		"LightMuted":   {TARGET_LIGHT_LUMA, MIN_LIGHT_LUMA, 1, TARGET_MUTED_SATURATION, 0, MAX_MUTED_SATURATION},
-		"DarkMuted":    {TARGET_DARK_LUMA, 0, MAX_DARK_LUMA, TARGET_MUTED_SATURATION, 0, MAX_MUTED_SATURATION},
-	}
 	res := make(map[string]*Swatch)
-	for name, args := range profiles {
-		sw := p.findColor(args...)
-		if sw != nil {
-			sw.Name = name
-			res[name] = sw
+	for _, r := range p.ExtractAwesomeOrdered() {
+		if !r.Missing() {
+			res[r.Target.Name] = r.Swatch
 		}
 	}
+	return res
+}
 
-	if _, vib := res["Vibrant"]; !vib {
-		if darkvib, ok := res["DarkVibrant"]; ok {
-			h, s, l := rgbToHsl(int(darkvib.Color))
-			l = TARGET_NORMAL_LUMA
-			res["Vibrant"] = &Swatch{Name: "Vibrant", Color: Color(hslToRgb(h, s, l))}
+// Same as ExtractAwesome, but returns one Result for every Target the
+// Palette was created with, in order, including those without a Swatch.
+func (p *Palette) ExtractAwesomeOrdered() []Result {
+	res := p.ExtractOrdered()
+
+	byName := func(name string) *Result {
+		for i := range res {
+			if res[i].Target.Name == name {
+				return &res[i]
+			}
 		}
+		return nil
 	}
-	if _, darkvib := res["DarkVibrant"]; !darkvib {
-		if vib, ok := res["Vibrant"]; ok {
-			h, s, l := rgbToHsl(int(vib.Color))
+	vib, darkvib := byName("Vibrant"), byName("DarkVibrant")
+	if vib != nil && darkvib != nil {
+		if vib.Missing() && !darkvib.Missing() {
+			h, s, l := rgbToHsl(int(darkvib.Swatch.Color))
+			l = TARGET_NORMAL_LUMA
+			vib.Swatch = &Swatch{Name: "Vibrant", Color: Color(hslToRgb(h, s, l))}
+		}
+		if darkvib.Missing() && !vib.Missing() {
+			h, s, l := rgbToHsl(int(vib.Swatch.Color))
 			l = TARGET_DARK_LUMA
-			res["DarkVibrant"] = &Swatch{Name: "DarkVibrant", Color: Color(hslToRgb(h, s, l))}
+			darkvib.Swatch = &Swatch{Name: "DarkVibrant", Color: Color(hslToRgb(h, s, l))}
 		}
 	}
 	return res
 }
 
-func (p *Palette) isAlreadySelected(swatch *Swatch) bool {
-	for _, sw := range p.selected {
-		if swatch == sw {
-			return true
-		}
+// Result pairs a Target with the Swatch selected for it.
+type Result struct {
+	Target *Target
+	Swatch *Swatch // nil if no Swatch matched Target
+}
+
+// true if no Swatch matched Target
+func (r Result) Missing() bool {
+	return r.Swatch == nil
+}
+
+// true if the image was found to be (effectively) grayscale, in which case
+// Options.GrayscaleTargets and Options.GrayscaleFilters were used instead
+// of Options.Targets and Options.Filters.
+func (p *Palette) IsGrayscale() bool {
+	return p.grayscale
+}
+
+// Returns copies of all of the Swatches in the quantized palette.
+func (p *Palette) Swatches() []*Swatch {
+	res := make([]*Swatch, len(p.swatches))
+	for i, sw := range p.swatches {
+		cp := *sw
+		res[i] = &cp
 	}
-	return false
+	return res
 }
 
-// don't worry about it
-func (p *Palette) findColor(params ...float64) *Swatch {
-	if len(params) != 6 {
-		panic("not enough arguments in call to p.FindColor")
+// Same as Swatches, sorted by order.
+func (p *Palette) SortedSwatches(order SwatchOrder) []*Swatch {
+	res := p.Swatches()
+	SortSwatches(res, order)
+	return res
+}
+
+// Returns a copy of the Swatch with the highest Population,
+// or nil if the Palette is empty.
+func (p *Palette) Dominant() *Swatch {
+	if len(p.swatches) == 0 {
+		return nil
 	}
+	return p.SortedSwatches(ByPopulation)[0]
+}
 
-	targetLuma := params[0]
-	minLuma := params[1]
-	maxLuma := params[2]
-	targetSaturation := params[3]
-	minSaturation := params[4]
-	maxSaturation := params[5]
+// Returns copies of the Targets this Palette was created with, see
+// Options.Targets.
+func (p *Palette) Targets() []*Target {
+	return copyTargets(p.targets)
+}
 
-	return p.FindColor(targetLuma, minLuma, maxLuma, targetSaturation, minSaturation, maxSaturation)
+// Finds the best Swatch for each of targets, in order. If no targets are
+// given, copies of the Targets this Palette was created with are used (see
+// Targets), so the keys of the returned map are not e.g. vibrant.Vibrant.
+//
+// Targets without a matching Swatch are not present in the returned map.
+// The returned Swatches are copies and may be modified freely.
+func (p *Palette) Extract(targets ...*Target) map[*Target]*Swatch {
+	res := make(map[*Target]*Swatch)
+	for _, r := range p.ExtractOrdered(targets...) {
+		if !r.Missing() {
+			res[r.Target] = r.Swatch
+		}
+	}
+	return res
+}
+
+// Same as Extract, but returns one Result for each of targets, in order,
+// including those without a Swatch.
+func (p *Palette) ExtractOrdered(targets ...*Target) []Result {
+	if len(targets) == 0 {
+		targets = p.Targets()
+	}
+	sel := p.newSelection()
+	res := make([]Result, len(targets))
+	for i, t := range targets {
+		res[i] = Result{Target: t, Swatch: sel.find(t)}
+	}
+	return res
 }
 
 // Finds a Swatch which best matches the specified parameters.
 //
-// See also package constants.
+// See also package constants and FindTarget.
 func (p *Palette) FindColor(targetLuma, minLuma, maxLuma, targetSaturation, minSaturation, maxSaturation float64) *Swatch {
+	return p.FindTarget(&Target{
+		MinSaturation:    minSaturation,
+		TargetSaturation: targetSaturation,
+		MaxSaturation:    maxSaturation,
+		MinLightness:     minLuma,
+		TargetLightness:  targetLuma,
+		MaxLightness:     maxLuma,
+		Exclusive:        true,
+	})
+}
+
+// Finds a Swatch which best matches t.
+//
+// Every call is independent of any other, use Extract to find Swatches
+// for several Targets at once.
+func (p *Palette) FindTarget(t *Target) *Swatch {
+	return p.newSelection().find(t)
+}
+
+// selection holds the state of a single Extract call, i.e. which Swatches
+// have already been picked by an Exclusive Target, so that the Palette
+// itself never changes.
+type selection struct {
+	palette *Palette
+	used    map[*Swatch]bool
+}
+
+func (p *Palette) newSelection() *selection {
+	return &selection{palette: p, used: make(map[*Swatch]bool)}
+}
+
+// Returns a copy of the Swatch which best matches t with Name and
+// MatchedPopulation set, or nil if there is none.
+func (sel *selection) find(t *Target) *Swatch {
 	var swatch *Swatch
 	var maxValue float64 = 0
 	population := 0
-	for _, sw := range p.swatches {
+	for _, sw := range sel.palette.swatches {
 		_, sat, luma := rgbToHsl(int(sw.Color))
-		if sat >= minSaturation && sat <= maxSaturation && luma >= minLuma && luma <= maxLuma && !p.isAlreadySelected(sw) {
+		if t.matches(sat, luma) && !sel.used[sw] {
 			population += sw.Population
-			value := weightedMean(invertDiff(sat, targetSaturation), WEIGHT_SATURATION, invertDiff(luma, targetLuma), WEIGHT_LUMA, float64(sw.Population)/float64(p.highestPopulation), WEIGHT_POPULATION)
+			value := t.score(sat, luma, sw.weight, sel.palette.highestWeight)
 			if swatch == nil || value > maxValue {
 				swatch = sw
 				maxValue = value
 			}
 		}
 	}
-	if swatch != nil {
-		swatch.Population = population
-		p.selected = append(p.selected, swatch)
+	if swatch == nil {
+		return nil
+	}
+	if t.Exclusive {
+		sel.used[swatch] = true
 	}
-	return swatch
+	res := *swatch
+	res.Name = t.Name
+	res.MatchedPopulation = population
+	return &res
 }
 
 // Returns a value in the range 0-1.
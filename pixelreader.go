@@ -0,0 +1,101 @@
+package vibrant
+
+import (
+	"image"
+	"image/color"
+)
+
+// Returns the color of the pixel at x, y as alpha-premultiplied components
+// in the range 0-0xffff, exactly like image.Image.At(x, y).RGBA() does.
+type pixelReader func(x, y int) (r, g, b, a uint32)
+
+// Returns a pixelReader for img which reads the pixels of the common image
+// types directly, without allocating a color.Color for every pixel.
+// Other types fall back to img.At().
+func newPixelReader(img image.Image) pixelReader {
+	switch img := img.(type) {
+	case *image.RGBA:
+		return func(x, y int) (r, g, b, a uint32) {
+			i := img.PixOffset(x, y)
+			s := img.Pix[i : i+4 : i+4]
+			return uint32(s[0]) * 0x101, uint32(s[1]) * 0x101, uint32(s[2]) * 0x101, uint32(s[3]) * 0x101
+		}
+	case *image.NRGBA:
+		return func(x, y int) (r, g, b, a uint32) {
+			i := img.PixOffset(x, y)
+			s := img.Pix[i : i+4 : i+4]
+			a = uint32(s[3]) * 0x101
+			if a == 0xffff {
+				return uint32(s[0]) * 0x101, uint32(s[1]) * 0x101, uint32(s[2]) * 0x101, a
+			}
+			// see color.NRGBA.RGBA()
+			r = uint32(s[0]) * 0x101 * a / 0xffff
+			g = uint32(s[1]) * 0x101 * a / 0xffff
+			b = uint32(s[2]) * 0x101 * a / 0xffff
+			return r, g, b, a
+		}
+	case *image.YCbCr:
+		return func(x, y int) (r, g, b, a uint32) {
+			return img.YCbCrAt(x, y).RGBA()
+		}
+	case *image.Gray:
+		return func(x, y int) (r, g, b, a uint32) {
+			v := uint32(img.Pix[img.PixOffset(x, y)]) * 0x101
+			return v, v, v, 0xffff
+		}
+	case *image.CMYK:
+		return func(x, y int) (r, g, b, a uint32) {
+			return img.CMYKAt(x, y).RGBA()
+		}
+	case *image.Paletted:
+		// converting the palette once is enough
+		palette := make([][4]uint32, len(img.Palette))
+		for i, c := range img.Palette {
+			if c == nil {
+				continue
+			}
+			r, g, b, a := c.RGBA()
+			palette[i] = [4]uint32{r, g, b, a}
+		}
+		return func(x, y int) (r, g, b, a uint32) {
+			i := int(img.Pix[img.PixOffset(x, y)])
+			if i >= len(palette) {
+				return 0, 0, 0, 0
+			}
+			c := palette[i]
+			return c[0], c[1], c[2], c[3]
+		}
+	}
+	return func(x, y int) (r, g, b, a uint32) {
+		c := img.At(x, y)
+		if c == nil {
+			// e.g. an *image.Paletted without a palette
+			return 0, 0, 0, 0
+		}
+		return c.RGBA()
+	}
+}
+
+// Takes the output of a pixelReader and normalizes it into r, g, b
+// components in the range of 0-255, taking alpha into account:
+// returns false if alpha is below threshold (in the range 0-255),
+// otherwise the pixel is composited over background or, if background is
+// nil, un-premultiplied, so semi-transparent pixels keep their actual color
+func alphaToRgb(cr, cg, cb, ca uint32, threshold uint8, background color.Color) (r, g, b int, ok bool) {
+	if ca>>8 < uint32(threshold) || ca == 0 && background == nil {
+		return 0, 0, 0, false
+	}
+	switch {
+	case ca == 0xffff:
+	case background != nil:
+		br, bg, bb, _ := background.RGBA()
+		cr += br * (0xffff - ca) / 0xffff
+		cg += bg * (0xffff - ca) / 0xffff
+		cb += bb * (0xffff - ca) / 0xffff
+	case ca > 0:
+		cr = cr * 0xffff / ca
+		cg = cg * 0xffff / ca
+		cb = cb * 0xffff / ca
+	}
+	return int(cr >> 8), int(cg >> 8), int(cb >> 8), true
+}
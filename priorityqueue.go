@@ -1,27 +1,31 @@
 package vibrant
 
-// Simple priorityQueue taken directly from the example for container/heap
-type priorityQueue []*vbox
+// Simple priorityQueue taken directly from the example for container/heap,
+// ordered by priority, see SplitPriority.
+type priorityQueue struct {
+	items    []*vbox
+	priority SplitPriority
+}
 
-func (pq priorityQueue) Len() int { return len(pq) }
+func (pq *priorityQueue) Len() int { return len(pq.items) }
 
-func (pq priorityQueue) Less(i, j int) bool {
-	return pq[i].Volume() > pq[j].Volume()
+func (pq *priorityQueue) Less(i, j int) bool {
+	return pq.priority.of(pq.items[i]) > pq.priority.of(pq.items[j])
 }
 
-func (pq priorityQueue) Swap(i, j int) {
-	pq[i], pq[j] = pq[j], pq[i]
+func (pq *priorityQueue) Swap(i, j int) {
+	pq.items[i], pq.items[j] = pq.items[j], pq.items[i]
 }
 
 func (pq *priorityQueue) Push(x interface{}) {
 	item := x.(*vbox)
-	*pq = append(*pq, item)
+	pq.items = append(pq.items, item)
 }
 
 func (pq *priorityQueue) Pop() interface{} {
-	old := *pq
+	old := pq.items
 	n := len(old)
 	item := old[n-1]
-	*pq = old[0 : n-1]
+	pq.items = old[0 : n-1]
 	return item
 }
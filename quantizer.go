@@ -0,0 +1,90 @@
+package vibrant
+
+import "context"
+
+// A Quantizer reduces the colors of a Histogram to a palette.
+//
+// The Histogram has already been filtered (see Filter) and always has more
+// than maxColors colors, otherwise no quantization takes place. Swatches
+// returned by a Quantizer are filtered again afterwards and only need to
+// have Color and Population set.
+//
+// The Histogram may be reused once Quantize returns, so it must not be
+// kept.
+//
+// See also Options.Quantizer.
+type Quantizer interface {
+	Quantize(histogram *Histogram, maxColors int) []*Swatch
+}
+
+// A Quantizer which stops early with ctx.Err() if ctx is done, see
+// NewPaletteContext. Other Quantizers always run to completion.
+//
+// All of the Quantizers in this package implement ContextQuantizer.
+type ContextQuantizer interface {
+	Quantizer
+	QuantizeContext(ctx context.Context, histogram *Histogram, maxColors int) ([]*Swatch, error)
+}
+
+// Calls q.QuantizeContext if q is a ContextQuantizer, q.Quantize otherwise.
+func quantizeContext(ctx context.Context, q Quantizer, histo *Histogram, maxColors int) ([]*Swatch, error) {
+	if cq, ok := q.(ContextQuantizer); ok {
+		return cq.QuantizeContext(ctx, histo, maxColors)
+	}
+	return q.Quantize(histo, maxColors), ctx.Err()
+}
+
+// The Quantizer used by NewPalette, see colorCutQuantizer and MedianCut.
+var MedianCutQuantizer Quantizer = MedianCut{}
+
+// Builds an octree of the colors in the histogram and merges its least
+// populated branches until at most maxColors leaves remain. Unlike
+// MedianCutQuantizer it tends to favor the most common colors, and may
+// return somewhat fewer than maxColors Swatches.
+var OctreeQuantizer Quantizer = octreeQuantizer{}
+
+// Xiaolin Wu's variance minimizing quantizer, as used by Material's color
+// utilities. Finds small but distinct accents which MedianCutQuantizer
+// tends to merge into larger boxes. Colors are reduced to 5 bits per
+// component internally, so no more than 32768 Swatches are returned.
+var WuQuantizer Quantizer = wuQuantizer{}
+
+// Quantizes histo with q, see Options.Quantizer and Options.Filters.
+// valid is used for the colors of histo allowed by filters.
+func quantize(ctx context.Context, q Quantizer, histo, valid *Histogram, maxColors int, filters []Filter) ([]*Swatch, error) {
+	if q == nil {
+		q = MedianCutQuantizer
+	}
+	valid = histo.filter(filters, valid)
+	var quantized []*Swatch
+	if valid.Len() <= maxColors {
+		// note: no quantization actually occurs
+		swatches := make([]Swatch, valid.Len())
+		quantized = make([]*Swatch, valid.Len())
+		for i, c := range valid.colors {
+			swatches[i] = valid.newSwatch(Color(c), valid.counts[i])
+			quantized[i] = &swatches[i]
+		}
+	} else {
+		var err error
+		quantized, err = quantizeContext(ctx, q, valid, maxColors)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	total := histo.total()
+	swatches := make([]*Swatch, 0, len(quantized))
+	for _, sw := range quantized {
+		if !shouldIgnoreColorSwatch(sw, filters) {
+			// Swatches from Quantizers outside of this package only
+			// have a Population
+			if sw.weight == 0 {
+				sw.weight = int64(float64(sw.Population) * histo.perPixel())
+			}
+			sw.Fraction = float64(sw.weight) / float64(total)
+			swatches = append(swatches, sw)
+		}
+	}
+	return swatches, nil
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package vibrant
+
+// sync.Pool drops items at random with the race detector, so allocation
+// counts aren't meaningful, see TestExtractorAllocs.
+const raceEnabled = true
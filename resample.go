@@ -0,0 +1,63 @@
+package vibrant
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/nfnt/resize"
+)
+
+// Decides how an image is scaled down, see Options.Resampler.
+type Resampler int
+
+const (
+	// Bilinear interpolation, which is what NewPalette uses.
+	ResampleBilinear Resampler = iota
+	// Every pixel is taken from the source pixel nearest to its center,
+	// so no colors are blended (or invented at edges) at all.
+	ResampleNearest
+	// Every pixel is the average of the source pixels it covers.
+	ResampleBox
+	// Lanczos interpolation (a=3), sharper than bilinear.
+	ResampleLanczos
+)
+
+// Scales img to width x height with r.
+func (r Resampler) resize(width, height int, img image.Image) image.Image {
+	switch r {
+	case ResampleNearest:
+		return &sampledImage{img, image.Rect(0, 0, width, height)}
+	case ResampleBox:
+		// nfnt's "nearest neighbor" averages all of the pixels under
+		// the destination pixel when scaling down
+		return resize.Resize(uint(width), uint(height), img, resize.NearestNeighbor)
+	case ResampleLanczos:
+		return resize.Resize(uint(width), uint(height), img, resize.Lanczos3)
+	}
+	return resize.Resize(uint(width), uint(height), img, resize.Bilinear)
+}
+
+// Samples the source image at the center of each of its own pixels,
+// without copying it.
+type sampledImage struct {
+	source image.Image
+	bounds image.Rectangle
+}
+
+func (s *sampledImage) ColorModel() color.Model {
+	return s.source.ColorModel()
+}
+
+func (s *sampledImage) Bounds() image.Rectangle {
+	return s.bounds
+}
+
+func (s *sampledImage) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(s.bounds)) {
+		return color.Transparent
+	}
+	src := s.source.Bounds()
+	sx := src.Min.X + (2*(x-s.bounds.Min.X)+1)*src.Dx()/(2*s.bounds.Dx())
+	sy := src.Min.Y + (2*(y-s.bounds.Min.Y)+1)*src.Dy()/(2*s.bounds.Dy())
+	return s.source.At(sx, sy)
+}
@@ -2,6 +2,7 @@ package vibrant
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -9,6 +10,19 @@ type Swatch struct {
 	Color      Color
 	Population int
 	Name       string // might be empty
+
+	// Population as a fraction of the total number of pixels sampled
+	// from the image, in the range 0-1.
+	Fraction float64
+
+	// Sum of the Population of every Swatch which was in range of the
+	// Target this Swatch was selected for, see Palette.Extract.
+	MatchedPopulation int
+
+	// Population before rounding, in the units of the Histogram's counts,
+	// see Histogram.newSwatch. Set by quantize for Swatches from
+	// Quantizers outside of this package.
+	weight int64
 }
 
 // Convenience method that returns CSS e.g.
@@ -21,3 +35,35 @@ func (sw *Swatch) String() string {
 		sw.Color.BodyTextColor(),
 	)
 }
+
+// Used to sort Swatches, see SortSwatches.
+type SwatchOrder int
+
+const (
+	ByPopulation SwatchOrder = iota // highest Population first
+	ByHue                           // red, yellow, green, cyan, blue, magenta
+	ByLightness                     // darkest first
+)
+
+// Sorts swatches in place. Ties are broken by Color so the result is
+// always the same for the same input.
+func SortSwatches(swatches []*Swatch, order SwatchOrder) {
+	key := func(sw *Swatch) float64 {
+		switch order {
+		case ByHue:
+			h, _, _ := sw.Color.HSL()
+			return h
+		case ByLightness:
+			_, _, l := sw.Color.HSL()
+			return l
+		}
+		return -float64(sw.Population)
+	}
+	sort.SliceStable(swatches, func(i, j int) bool {
+		ki, kj := key(swatches[i]), key(swatches[j])
+		if ki != kj {
+			return ki < kj
+		}
+		return swatches[i].Color < swatches[j].Color
+	})
+}
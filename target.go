@@ -0,0 +1,214 @@
+package vibrant
+
+// Used by the neutral targets, see NeutralTargets().
+const (
+	MAX_NEUTRAL_SATURATION = 0.25
+	TARGET_BLACK_LUMA      = 0.05
+	MAX_BLACK_LUMA         = 0.2
+	TARGET_GRAY_LUMA       = 0.5
+	MIN_WHITE_LUMA         = 0.8
+	TARGET_WHITE_LUMA      = 0.95
+)
+
+// A Target describes the kind of color ExtractAwesome should look for,
+// modelled after Android's Target class.
+//
+// Swatches with a saturation or lightness outside of the Min/Max range are
+// never selected. Of the remaining, the one with the highest weighted mean of
+// closeness to TargetSaturation, closeness to TargetLightness and population
+// wins.
+type Target struct {
+	Name string
+
+	MinSaturation    float64
+	TargetSaturation float64
+	MaxSaturation    float64
+
+	MinLightness    float64
+	TargetLightness float64
+	MaxLightness    float64
+
+	// If all three are 0, WEIGHT_SATURATION, WEIGHT_LUMA and
+	// WEIGHT_POPULATION are used instead.
+	SaturationWeight float64
+	LightnessWeight  float64
+	PopulationWeight float64
+
+	// If true, the Swatch selected for this Target will not be
+	// selected for any following Targets.
+	Exclusive bool
+}
+
+// Returns a Target which accepts any color, with default weights and
+// Exclusive set. Adjust the fields as needed, e.g.
+//
+//	accent := vibrant.NewTarget("Accent")
+//	accent.MinSaturation = 0.6
+//	accent.TargetSaturation = 1
+func NewTarget(name string) *Target {
+	return &Target{
+		Name:             name,
+		MinSaturation:    0,
+		TargetSaturation: 0.5,
+		MaxSaturation:    1,
+		MinLightness:     0,
+		TargetLightness:  0.5,
+		MaxLightness:     1,
+		SaturationWeight: WEIGHT_SATURATION,
+		LightnessWeight:  WEIGHT_LUMA,
+		PopulationWeight: WEIGHT_POPULATION,
+		Exclusive:        true,
+	}
+}
+
+// Returns copies of targets, so that changing them afterwards (or changing
+// the package level targets such as Vibrant) has no effect on the copies.
+func copyTargets(targets []*Target) []*Target {
+	res := make([]*Target, len(targets))
+	for i, t := range targets {
+		cp := *t
+		res[i] = &cp
+	}
+	return res
+}
+
+func (t *Target) weights() (saturation, lightness, population float64) {
+	if t.SaturationWeight == 0 && t.LightnessWeight == 0 && t.PopulationWeight == 0 {
+		return WEIGHT_SATURATION, WEIGHT_LUMA, WEIGHT_POPULATION
+	}
+	return t.SaturationWeight, t.LightnessWeight, t.PopulationWeight
+}
+
+// true if a color with saturation s and lightness l is within range.
+func (t *Target) matches(s, l float64) bool {
+	return s >= t.MinSaturation && s <= t.MaxSaturation && l >= t.MinLightness && l <= t.MaxLightness
+}
+
+// Returns the weighted mean described above, highestWeight is the
+// un-rounded population of the most populous Swatch in the Palette, see
+// Swatch.weight. Populations are not rounded so that fractions of a pixel
+// (see Options.WeightByMask) still count.
+func (t *Target) score(s, l float64, weight, highestWeight int64) float64 {
+	ws, wl, wp := t.weights()
+	population := 0.0
+	if highestWeight > 0 {
+		population = float64(weight) / float64(highestWeight)
+	}
+	return weightedMean(
+		invertDiff(s, t.TargetSaturation), ws,
+		invertDiff(l, t.TargetLightness), wl,
+		population, wp,
+	)
+}
+
+// The default targets, see package constants. These are only used through
+// DefaultTargets(), which returns copies, so changing them only affects
+// Palettes created afterwards.
+var (
+	Vibrant = &Target{
+		Name:             "Vibrant",
+		MinSaturation:    MIN_VIBRANT_SATURATION,
+		TargetSaturation: TARGET_VIBRANT_SATURATION,
+		MaxSaturation:    1,
+		MinLightness:     MIN_NORMAL_LUMA,
+		TargetLightness:  TARGET_NORMAL_LUMA,
+		MaxLightness:     MAX_NORMAL_LUMA,
+		Exclusive:        true,
+	}
+	LightVibrant = &Target{
+		Name:             "LightVibrant",
+		MinSaturation:    MIN_VIBRANT_SATURATION,
+		TargetSaturation: TARGET_VIBRANT_SATURATION,
+		MaxSaturation:    1,
+		MinLightness:     MIN_LIGHT_LUMA,
+		TargetLightness:  TARGET_LIGHT_LUMA,
+		MaxLightness:     1,
+		Exclusive:        true,
+	}
+	DarkVibrant = &Target{
+		Name:             "DarkVibrant",
+		MinSaturation:    MIN_VIBRANT_SATURATION,
+		TargetSaturation: TARGET_VIBRANT_SATURATION,
+		MaxSaturation:    1,
+		MinLightness:     0,
+		TargetLightness:  TARGET_DARK_LUMA,
+		MaxLightness:     MAX_DARK_LUMA,
+		Exclusive:        true,
+	}
+	Muted = &Target{
+		Name:             "Muted",
+		MinSaturation:    0,
+		TargetSaturation: TARGET_MUTED_SATURATION,
+		MaxSaturation:    MAX_MUTED_SATURATION,
+		MinLightness:     MIN_NORMAL_LUMA,
+		TargetLightness:  TARGET_NORMAL_LUMA,
+		MaxLightness:     MAX_NORMAL_LUMA,
+		Exclusive:        true,
+	}
+	LightMuted = &Target{
+		Name:             "LightMuted",
+		MinSaturation:    0,
+		TargetSaturation: TARGET_MUTED_SATURATION,
+		MaxSaturation:    MAX_MUTED_SATURATION,
+		MinLightness:     MIN_LIGHT_LUMA,
+		TargetLightness:  TARGET_LIGHT_LUMA,
+		MaxLightness:     1,
+		Exclusive:        true,
+	}
+	DarkMuted = &Target{
+		Name:             "DarkMuted",
+		MinSaturation:    0,
+		TargetSaturation: TARGET_MUTED_SATURATION,
+		MaxSaturation:    MAX_MUTED_SATURATION,
+		MinLightness:     0,
+		TargetLightness:  TARGET_DARK_LUMA,
+		MaxLightness:     MAX_DARK_LUMA,
+		Exclusive:        true,
+	}
+)
+
+// The neutral targets, see NeutralTargets().
+var (
+	Black = &Target{
+		Name:             "Black",
+		MinSaturation:    0,
+		TargetSaturation: 0,
+		MaxSaturation:    MAX_NEUTRAL_SATURATION,
+		MinLightness:     0,
+		TargetLightness:  TARGET_BLACK_LUMA,
+		MaxLightness:     MAX_BLACK_LUMA,
+		Exclusive:        true,
+	}
+	Gray = &Target{
+		Name:             "Gray",
+		MinSaturation:    0,
+		TargetSaturation: 0,
+		MaxSaturation:    MAX_NEUTRAL_SATURATION,
+		MinLightness:     MAX_BLACK_LUMA,
+		TargetLightness:  TARGET_GRAY_LUMA,
+		MaxLightness:     MIN_WHITE_LUMA,
+		Exclusive:        true,
+	}
+	White = &Target{
+		Name:             "White",
+		MinSaturation:    0,
+		TargetSaturation: 0,
+		MaxSaturation:    MAX_NEUTRAL_SATURATION,
+		MinLightness:     MIN_WHITE_LUMA,
+		TargetLightness:  TARGET_WHITE_LUMA,
+		MaxLightness:     1,
+		Exclusive:        true,
+	}
+)
+
+// Returns copies of the three targets used by ExtractAwesome for grayscale
+// images, in order. They can also be added to the default targets to pick
+// out the neutral colors of any image, see Options.Targets.
+func NeutralTargets() []*Target {
+	return copyTargets([]*Target{Black, Gray, White})
+}
+
+// Returns copies of the six targets used by ExtractAwesome, in order.
+func DefaultTargets() []*Target {
+	return copyTargets([]*Target{Vibrant, LightVibrant, DarkVibrant, Muted, LightMuted, DarkMuted})
+}
@@ -22,11 +22,17 @@ type vbox struct {
 	minBlue     int
 	maxBlue     int
 	colors      []int
-	populations map[int]int
+	populations map[int]int64 // see pixelWeight
+	weight      int64         // sum of populations of the colors within
 }
 
-func newVbox(lowerIndex, upperIndex int, colors []int, populations map[int]int) *vbox {
-	v := &vbox{lowerIndex: lowerIndex, upperIndex: upperIndex, colors: colors, populations: populations}
+func newVbox(lowerIndex, upperIndex int, colors []int, populations map[int]int64) *vbox {
+	return (&vbox{}).init(lowerIndex, upperIndex, colors, populations)
+}
+
+// Same as newVbox, but uses v instead of allocating a new vbox.
+func (v *vbox) init(lowerIndex, upperIndex int, colors []int, populations map[int]int64) *vbox {
+	*v = vbox{lowerIndex: lowerIndex, upperIndex: upperIndex, colors: colors, populations: populations}
 	v.fitBox()
 	return v
 }
@@ -40,8 +46,10 @@ func (v *vbox) fitBox() {
 	v.maxRed = 0
 	v.maxGreen = 0
 	v.maxBlue = 0
+	v.weight = 0
 
 	for i := v.lowerIndex; i <= v.upperIndex; i++ {
+		v.weight += v.populations[v.colors[i]]
 		r, g, b := unpackColor(v.colors[i])
 		if r > v.maxRed {
 			v.maxRed = r
@@ -72,10 +80,13 @@ func (v *vbox) CanSplit() bool {
 	return (v.upperIndex - v.lowerIndex + 1) > 1
 }
 
-// Split this color box at the mid-point along its longest dimension
-func (v *vbox) Split() *vbox {
+// Split this color box along its longest dimension, at the mid-point
+// or the median by population, see SplitPoint. The upper half of the colors
+// is moved into a new box, which is stored in into unless it is nil.
+// Returns nil if this box only has 1 color, see CanSplit.
+func (v *vbox) Split(at SplitPoint, into *vbox) *vbox {
 	if !v.CanSplit() {
-		panic("Cannot split a box with only 1 color!")
+		return nil
 	}
 
 	lenRed := v.maxRed - v.minRed
@@ -122,32 +133,55 @@ func (v *vbox) Split() *vbox {
 		midPoint = (v.minBlue + v.maxBlue) / 2
 	}
 
-	// Iterate over the colors until a color is found with at least the
-	// midpoint of the whole box's dimension midpoint.
 	splitPoint := v.lowerIndex
-loop:
-	for i := v.lowerIndex; i <= v.upperIndex; i++ {
-		r, g, b := unpackColor(v.colors[i])
-		switch longestDim {
-		case componentRed:
-			if r >= midPoint {
-				splitPoint = i
-				break loop
+	if at == SplitMedian {
+		// Iterate over the colors until at least half of the
+		// population of the box is below the split point, making sure
+		// both halves keep at least one color.
+		var sum int64
+		for i := v.lowerIndex; i < v.upperIndex; i++ {
+			splitPoint = i
+			sum += v.populations[v.colors[i]]
+			if 2*sum >= v.weight {
+				break
 			}
-		case componentGreen:
-			if g >= midPoint {
-				splitPoint = i
-				break loop
-			}
-		case componentBlue:
-			if b >= midPoint {
-				splitPoint = i
-				break loop
+		}
+	} else {
+		// Iterate over the colors until a color is found with at least the
+		// midpoint of the whole box's dimension midpoint.
+	loop:
+		for i := v.lowerIndex; i <= v.upperIndex; i++ {
+			r, g, b := unpackColor(v.colors[i])
+			switch longestDim {
+			case componentRed:
+				if r >= midPoint {
+					splitPoint = i
+					break loop
+				}
+			case componentGreen:
+				if g >= midPoint {
+					splitPoint = i
+					break loop
+				}
+			case componentBlue:
+				if b >= midPoint {
+					splitPoint = i
+					break loop
+				}
 			}
 		}
 	}
 
-	vbox := newVbox(splitPoint+1, v.upperIndex, v.colors, v.populations)
+	// If only the last color reaches the midpoint, it has to go into the
+	// new box on its own, otherwise the new box would be empty.
+	if splitPoint >= v.upperIndex {
+		splitPoint = v.upperIndex - 1
+	}
+
+	if into == nil {
+		into = &vbox{}
+	}
+	vbox := into.init(splitPoint+1, v.upperIndex, v.colors, v.populations)
 
 	// Now change this box's upperIndex and recompute the color boundaries
 	v.upperIndex = splitPoint
@@ -187,25 +221,63 @@ func (v *vbox) modifySignificantOctet(dim int) {
 	}
 }
 
-func (v *vbox) AverageColor() *Swatch {
-	sumRed := 0
-	sumGreen := 0
-	sumBlue := 0
-	sumPop := 0
+// Returns the population weighted average of the colors in this box,
+// averaged in linear light if linear is true, see MedianCut.LinearLight.
+func (v *vbox) AverageColor(linear bool) Color {
+	if linear {
+		return v.linearAverageColor()
+	}
+	var sumRed, sumGreen, sumBlue, sumPop int64
 	for i := v.lowerIndex; i <= v.upperIndex; i++ {
 		color := v.colors[i]
 		r, g, b := unpackColor(color)
-		pop := v.populations[color]
+		pop := v.colorWeight(color)
 		sumPop += pop
-		sumRed += r * pop
-		sumGreen += g * pop
-		sumBlue += b * pop
+		sumRed += int64(r) * pop
+		sumGreen += int64(g) * pop
+		sumBlue += int64(b) * pop
 	}
 	avgRed := round(float64(sumRed) / float64(sumPop))
 	avgGreen := round(float64(sumGreen) / float64(sumPop))
 	avgBlue := round(float64(sumBlue) / float64(sumPop))
 
-	return &Swatch{Color: Color(packColor(avgRed, avgGreen, avgBlue)), Population: sumPop}
+	return Color(packColor(avgRed, avgGreen, avgBlue))
+}
+
+// Decodes the colors in this box to linear light, averages them, then
+// re-encodes the result to sRGB.
+func (v *vbox) linearAverageColor() Color {
+	var sumRed, sumGreen, sumBlue, sumPop float64
+	for i := v.lowerIndex; i <= v.upperIndex; i++ {
+		color := v.colors[i]
+		r, g, b := unpackColor(color)
+		pop := float64(v.colorWeight(color))
+		sumPop += pop
+		sumRed += srgbToLinear(float64(r)) * pop
+		sumGreen += srgbToLinear(float64(g)) * pop
+		sumBlue += srgbToLinear(float64(b)) * pop
+	}
+	avgRed := clampComponent(linearToSrgb(sumRed / sumPop))
+	avgGreen := clampComponent(linearToSrgb(sumGreen / sumPop))
+	avgBlue := clampComponent(linearToSrgb(sumBlue / sumPop))
+
+	return Color(packColor(avgRed, avgGreen, avgBlue))
+}
+
+// Returns the population of color, or 1 if nothing in this box has any
+// population, so that its colors are averaged evenly instead of dividing
+// by zero.
+func (v *vbox) colorWeight(color int) int64 {
+	if v.weight == 0 {
+		return 1
+	}
+	return v.populations[color]
+}
+
+// Returns the sum of the histogram counts of the colors in this box,
+// see pixelWeight.
+func (v *vbox) Weight() int64 {
+	return v.weight
 }
 
 // there is no math.Round ._.
@@ -115,7 +115,10 @@ func index(w http.ResponseWriter, r *http.Request) {
 	data.DataURI = fmt.Sprintf("data:%s;base64,%s", header.Header["Content-Type"][0], base64.StdEncoding.EncodeToString(buf.Bytes()))
 
 	start := time.Now()
-	palette, err := vibrant.NewPalette(img, data.MaxColors)
+	opts := vibrant.DefaultOptions()
+	opts.MaximumColorCount = data.MaxColors
+	// stops if the client goes away
+	palette, err := vibrant.NewPaletteContext(r.Context(), img, opts)
 	data.Benchmark = time.Since(start)
 	if err != nil {
 		setStatus(500)
@@ -123,9 +126,13 @@ func index(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	awesome := palette.ExtractAwesome()
 	stylesheet := ""
-	for _, sw := range awesome {
+	for _, res := range palette.ExtractAwesomeOrdered() {
+		if res.Missing() {
+			data.Missing = true
+			continue
+		}
+		sw := res.Swatch
 		stylesheet += fmt.Sprintf("%s\n", sw)
 		if sw.Name == "Vibrant" {
 			vendorPrefixingIsAWESOME := fmt.Sprintf("{\n    background-color: %s;\n   color: %s;\n}\n", sw.Color.RGBHex(), sw.Color.TitleTextColor())
@@ -133,7 +140,6 @@ func index(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	data.Stylesheet = stylesheet
-	data.Missing = len(awesome) != 6
 	setStatus(200)
 	data.Response = true
 	return
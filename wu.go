@@ -0,0 +1,332 @@
+package vibrant
+
+import "context"
+
+// Xiaolin Wu's greedy orthogonal bipartition quantizer, see
+// "Efficient Statistical Computations for Optimal Color Quantization",
+// Graphics Gems II, 1991.
+//
+// Ported from the QuantizerWu in Material's material-color-utilities.
+//
+// The color space is divided into a 32x32x32 grid of cells. For every cell
+// the cumulative moments of the colors in it and all cells "below" it are
+// precomputed, so the population, mean and variance of any box can be found
+// in constant time. Starting with the whole color space, the box with the
+// highest variance is repeatedly cut in two where doing so reduces the sum
+// of squared errors the most.
+
+const (
+	wuIndexBits  = 5
+	wuIndexCount = 1<<wuIndexBits + 1 // one extra for the cumulative sums
+	wuTotalSize  = wuIndexCount * wuIndexCount * wuIndexCount
+)
+
+const (
+	wuRed = iota
+	wuGreen
+	wuBlue
+)
+
+type wuBox struct {
+	r0, r1 int
+	g0, g1 int
+	b0, b1 int
+	vol    int
+}
+
+type wuQuantizer struct{}
+
+func (q wuQuantizer) Quantize(histo *Histogram, maxColors int) []*Swatch {
+	swatches, _ := q.QuantizeContext(context.Background(), histo, maxColors)
+	return swatches
+}
+
+func (wuQuantizer) QuantizeContext(ctx context.Context, histo *Histogram, maxColors int) ([]*Swatch, error) {
+	wu := newWuMoments(histo)
+	boxes, err := wu.createBoxes(ctx, maxColors)
+	if err != nil {
+		return nil, err
+	}
+	swatches := make([]*Swatch, 0, len(boxes))
+	for _, box := range boxes {
+		weight := wu.volume(box, wu.weights)
+		if weight <= 0 {
+			continue
+		}
+		r := round(float64(wu.volume(box, wu.momentsR)) / float64(weight))
+		g := round(float64(wu.volume(box, wu.momentsG)) / float64(weight))
+		b := round(float64(wu.volume(box, wu.momentsB)) / float64(weight))
+		sw := histo.newSwatch(Color(packColor(r, g, b)), weight)
+		swatches = append(swatches, &sw)
+	}
+	return swatches, nil
+}
+
+// The moment table, see pixelWeight for the unit of weights.
+type wuMoments struct {
+	weights  []int64
+	momentsR []int64
+	momentsG []int64
+	momentsB []int64
+	moments  []float64 // sum of r² + g² + b², weighted
+}
+
+func wuIndex(r, g, b int) int {
+	return r*wuIndexCount*wuIndexCount + g*wuIndexCount + b
+}
+
+func newWuMoments(histo *Histogram) *wuMoments {
+	wu := &wuMoments{
+		weights:  make([]int64, wuTotalSize),
+		momentsR: make([]int64, wuTotalSize),
+		momentsG: make([]int64, wuTotalSize),
+		momentsB: make([]int64, wuTotalSize),
+		moments:  make([]float64, wuTotalSize),
+	}
+	const shift = 8 - wuIndexBits
+	for i, c := range histo.colors {
+		r, g, b := unpackColor(c)
+		w := histo.counts[i]
+		idx := wuIndex(r>>shift+1, g>>shift+1, b>>shift+1)
+		wu.weights[idx] += w
+		wu.momentsR[idx] += int64(r) * w
+		wu.momentsG[idx] += int64(g) * w
+		wu.momentsB[idx] += int64(b) * w
+		wu.moments[idx] += float64(r*r+g*g+b*b) * float64(w)
+	}
+
+	// Turn the table into cumulative sums
+	for r := 1; r < wuIndexCount; r++ {
+		var (
+			area                [wuIndexCount]int64
+			areaR, areaG, areaB [wuIndexCount]int64
+			area2               [wuIndexCount]float64
+		)
+		for g := 1; g < wuIndexCount; g++ {
+			var line, lineR, lineG, lineB int64
+			var line2 float64
+			for b := 1; b < wuIndexCount; b++ {
+				idx := wuIndex(r, g, b)
+				line += wu.weights[idx]
+				lineR += wu.momentsR[idx]
+				lineG += wu.momentsG[idx]
+				lineB += wu.momentsB[idx]
+				line2 += wu.moments[idx]
+
+				area[b] += line
+				areaR[b] += lineR
+				areaG[b] += lineG
+				areaB[b] += lineB
+				area2[b] += line2
+
+				prev := wuIndex(r-1, g, b)
+				wu.weights[idx] = wu.weights[prev] + area[b]
+				wu.momentsR[idx] = wu.momentsR[prev] + areaR[b]
+				wu.momentsG[idx] = wu.momentsG[prev] + areaG[b]
+				wu.momentsB[idx] = wu.momentsB[prev] + areaB[b]
+				wu.moments[idx] = wu.moments[prev] + area2[b]
+			}
+		}
+	}
+	return wu
+}
+
+// Cuts the box with the highest variance in two until there are maxColors
+// boxes or no box can be cut any further. Stops early if ctx is done.
+func (wu *wuMoments) createBoxes(ctx context.Context, maxColors int) ([]*wuBox, error) {
+	// there can't be more boxes than cells
+	if maxColors > 1<<(3*wuIndexBits) {
+		maxColors = 1 << (3 * wuIndexBits)
+	}
+	boxes := make([]*wuBox, maxColors)
+	for i := range boxes {
+		boxes[i] = &wuBox{}
+	}
+	boxes[0].r1 = wuIndexCount - 1
+	boxes[0].g1 = wuIndexCount - 1
+	boxes[0].b1 = wuIndexCount - 1
+
+	variances := make([]float64, maxColors)
+	next := 0
+	for i := 1; i < maxColors; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if wu.cut(boxes[next], boxes[i]) {
+			variances[next] = wu.variance(boxes[next])
+			variances[i] = wu.variance(boxes[i])
+		} else {
+			variances[next] = 0
+			i--
+		}
+
+		next = 0
+		max := variances[0]
+		for j := 1; j <= i; j++ {
+			if variances[j] > max {
+				max = variances[j]
+				next = j
+			}
+		}
+		if max <= 0 {
+			return boxes[:i+1], nil
+		}
+	}
+	return boxes, nil
+}
+
+func (wu *wuMoments) variance(box *wuBox) float64 {
+	if box.vol <= 1 {
+		return 0
+	}
+	dr := float64(wu.volume(box, wu.momentsR))
+	dg := float64(wu.volume(box, wu.momentsG))
+	db := float64(wu.volume(box, wu.momentsB))
+	m := wu.moments
+	xx := m[wuIndex(box.r1, box.g1, box.b1)] -
+		m[wuIndex(box.r1, box.g1, box.b0)] -
+		m[wuIndex(box.r1, box.g0, box.b1)] +
+		m[wuIndex(box.r1, box.g0, box.b0)] -
+		m[wuIndex(box.r0, box.g1, box.b1)] +
+		m[wuIndex(box.r0, box.g1, box.b0)] +
+		m[wuIndex(box.r0, box.g0, box.b1)] -
+		m[wuIndex(box.r0, box.g0, box.b0)]
+	hypotenuse := dr*dr + dg*dg + db*db
+	return xx - hypotenuse/float64(wu.volume(box, wu.weights))
+}
+
+// Cuts one in two, moving the upper part into two. Returns false if one
+// cannot be cut.
+func (wu *wuMoments) cut(one, two *wuBox) bool {
+	wholeR := wu.volume(one, wu.momentsR)
+	wholeG := wu.volume(one, wu.momentsG)
+	wholeB := wu.volume(one, wu.momentsB)
+	wholeW := wu.volume(one, wu.weights)
+
+	cutR, maxR := wu.maximize(one, wuRed, one.r0+1, one.r1, wholeR, wholeG, wholeB, wholeW)
+	cutG, maxG := wu.maximize(one, wuGreen, one.g0+1, one.g1, wholeR, wholeG, wholeB, wholeW)
+	cutB, maxB := wu.maximize(one, wuBlue, one.b0+1, one.b1, wholeR, wholeG, wholeB, wholeW)
+
+	var direction int
+	switch {
+	case maxR >= maxG && maxR >= maxB:
+		if cutR < 0 {
+			return false
+		}
+		direction = wuRed
+	case maxG >= maxR && maxG >= maxB:
+		direction = wuGreen
+	default:
+		direction = wuBlue
+	}
+
+	two.r1, two.g1, two.b1 = one.r1, one.g1, one.b1
+	switch direction {
+	case wuRed:
+		one.r1 = cutR
+		two.r0, two.g0, two.b0 = one.r1, one.g0, one.b0
+	case wuGreen:
+		one.g1 = cutG
+		two.r0, two.g0, two.b0 = one.r0, one.g1, one.b0
+	case wuBlue:
+		one.b1 = cutB
+		two.r0, two.g0, two.b0 = one.r0, one.g0, one.b1
+	}
+	one.vol = (one.r1 - one.r0) * (one.g1 - one.g0) * (one.b1 - one.b0)
+	two.vol = (two.r1 - two.r0) * (two.g1 - two.g0) * (two.b1 - two.b0)
+	return true
+}
+
+// Finds the position between first and last along direction where cutting
+// box reduces the sum of squared errors the most. Returns -1 if there is none.
+func (wu *wuMoments) maximize(box *wuBox, direction, first, last int, wholeR, wholeG, wholeB, wholeW int64) (cut int, max float64) {
+	bottomR := wu.bottom(box, direction, wu.momentsR)
+	bottomG := wu.bottom(box, direction, wu.momentsG)
+	bottomB := wu.bottom(box, direction, wu.momentsB)
+	bottomW := wu.bottom(box, direction, wu.weights)
+
+	cut = -1
+	for i := first; i < last; i++ {
+		halfR := bottomR + wu.top(box, direction, i, wu.momentsR)
+		halfG := bottomG + wu.top(box, direction, i, wu.momentsG)
+		halfB := bottomB + wu.top(box, direction, i, wu.momentsB)
+		halfW := bottomW + wu.top(box, direction, i, wu.weights)
+		if halfW == 0 {
+			continue
+		}
+		temp := sumOfSquares(halfR, halfG, halfB) / float64(halfW)
+
+		halfR, halfG, halfB, halfW = wholeR-halfR, wholeG-halfG, wholeB-halfB, wholeW-halfW
+		if halfW == 0 {
+			continue
+		}
+		temp += sumOfSquares(halfR, halfG, halfB) / float64(halfW)
+
+		if temp > max {
+			max = temp
+			cut = i
+		}
+	}
+	return cut, max
+}
+
+func sumOfSquares(r, g, b int64) float64 {
+	fr, fg, fb := float64(r), float64(g), float64(b)
+	return fr*fr + fg*fg + fb*fb
+}
+
+// Returns the sum of moment over box.
+func (wu *wuMoments) volume(box *wuBox, m []int64) int64 {
+	return m[wuIndex(box.r1, box.g1, box.b1)] -
+		m[wuIndex(box.r1, box.g1, box.b0)] -
+		m[wuIndex(box.r1, box.g0, box.b1)] +
+		m[wuIndex(box.r1, box.g0, box.b0)] -
+		m[wuIndex(box.r0, box.g1, box.b1)] +
+		m[wuIndex(box.r0, box.g1, box.b0)] +
+		m[wuIndex(box.r0, box.g0, box.b1)] -
+		m[wuIndex(box.r0, box.g0, box.b0)]
+}
+
+// Returns the part of volume(box, m) which does not depend on the position
+// of a cut along direction, see top.
+func (wu *wuMoments) bottom(box *wuBox, direction int, m []int64) int64 {
+	switch direction {
+	case wuRed:
+		return -m[wuIndex(box.r0, box.g1, box.b1)] +
+			m[wuIndex(box.r0, box.g1, box.b0)] +
+			m[wuIndex(box.r0, box.g0, box.b1)] -
+			m[wuIndex(box.r0, box.g0, box.b0)]
+	case wuGreen:
+		return -m[wuIndex(box.r1, box.g0, box.b1)] +
+			m[wuIndex(box.r1, box.g0, box.b0)] +
+			m[wuIndex(box.r0, box.g0, box.b1)] -
+			m[wuIndex(box.r0, box.g0, box.b0)]
+	default:
+		return -m[wuIndex(box.r1, box.g1, box.b0)] +
+			m[wuIndex(box.r1, box.g0, box.b0)] +
+			m[wuIndex(box.r0, box.g1, box.b0)] -
+			m[wuIndex(box.r0, box.g0, box.b0)]
+	}
+}
+
+// Returns the sum of m over the part of box below position along direction,
+// minus bottom(box, direction, m).
+func (wu *wuMoments) top(box *wuBox, direction, position int, m []int64) int64 {
+	switch direction {
+	case wuRed:
+		return m[wuIndex(position, box.g1, box.b1)] -
+			m[wuIndex(position, box.g1, box.b0)] -
+			m[wuIndex(position, box.g0, box.b1)] +
+			m[wuIndex(position, box.g0, box.b0)]
+	case wuGreen:
+		return m[wuIndex(box.r1, position, box.b1)] -
+			m[wuIndex(box.r1, position, box.b0)] -
+			m[wuIndex(box.r0, position, box.b1)] +
+			m[wuIndex(box.r0, position, box.b0)]
+	default:
+		return m[wuIndex(box.r1, box.g1, position)] -
+			m[wuIndex(box.r1, box.g0, position)] -
+			m[wuIndex(box.r0, box.g1, position)] +
+			m[wuIndex(box.r0, box.g0, position)]
+	}
+}
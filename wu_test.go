@@ -0,0 +1,65 @@
+package vibrant
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestWuQuantizerClusters(t *testing.T) {
+	clusters := []color.NRGBA{
+		{0xe0, 0x20, 0x20, 0xff},
+		{0x20, 0xe0, 0x20, 0xff},
+		{0x20, 0x20, 0xe0, 0xff},
+		{0xe0, 0xe0, 0x20, 0xff},
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			c := clusters[(y/32)*2+x/32]
+			// a little noise around each center
+			c.R += uint8(x % 4)
+			c.G += uint8(y % 4)
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	opts := DefaultOptions()
+	opts.Quantizer = WuQuantizer
+	opts.Filters = nil
+	opts.ResizeBitmapArea = -1
+	opts.MaximumColorCount = 4
+	p, err := NewPaletteWithOptions(img, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	swatches := p.Swatches()
+	if len(swatches) != 4 {
+		t.Fatalf("got %d swatches, want 4: %v", len(swatches), swatches)
+	}
+	for _, sw := range swatches {
+		if sw.Population != 32*32 {
+			t.Errorf("%v: Population %d, want %d", sw, sw.Population, 32*32)
+		}
+	}
+}
+
+func TestWuQuantizerMaxColors(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 16), uint8(y * 16), 0x80, 0xff})
+		}
+	}
+	opts := DefaultOptions()
+	opts.ResizeBitmapArea = -1
+	hb := NewHistogramBuilder(opts)
+	if err := hb.Add(img, 1); err != nil {
+		t.Fatal(err)
+	}
+	h := hb.Histogram()
+	// more colors than there are cells in the moment table
+	swatches := WuQuantizer.Quantize(h, 1<<20)
+	if n := len(swatches); n == 0 || n > h.Len() {
+		t.Errorf("got %d swatches for %d colors", n, h.Len())
+	}
+}